@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RuleStats summarizes what one rule has done since the daemon started.
+type RuleStats struct {
+	Rule      string    `json:"rule"`
+	Processed int       `json:"processed"`
+	Failed    int       `json:"failed"`
+	Bytes     int64     `json:"bytes"`
+	Last      time.Time `json:"last,omitzero"`
+}
+
+// opsTracker keeps the most recent operations and per-rule totals in memory.
+type opsTracker struct {
+	mu     sync.Mutex
+	recent []HistoryEntry // ring buffer
+	next   int
+	full   bool
+	rules  map[string]*RuleStats
+}
+
+func newOpsTracker(size int) *opsTracker {
+	return &opsTracker{recent: make([]HistoryEntry, size), rules: make(map[string]*RuleStats)}
+}
+
+// Recent operations and rule statistics for the status API
+var ops = newOpsTracker(200)
+
+func (t *opsTracker) add(e HistoryEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recent[t.next] = e
+	t.next = (t.next + 1) % len(t.recent)
+	if t.next == 0 {
+		t.full = true
+	}
+
+	rs := t.rules[e.Rule]
+	if rs == nil {
+		rs = &RuleStats{Rule: e.Rule}
+		t.rules[e.Rule] = rs
+	}
+	if e.Error != "" {
+		rs.Failed++
+	} else {
+		rs.Processed++
+		if e.Action == "move" || e.Action == "copy" {
+			rs.Bytes += e.Size
+		}
+	}
+	rs.Last = e.Time
+}
+
+// latest returns up to n operations, newest first.
+func (t *opsTracker) latest(n int) []HistoryEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := t.next
+	if t.full {
+		count = len(t.recent)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	out := make([]HistoryEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, t.recent[(t.next-i+len(t.recent))%len(t.recent)])
+	}
+	return out
+}
+
+// stats returns totals for every configured rule plus any rule seen since,
+// in config order.
+func (t *opsTracker) stats(rules []Rule) []RuleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]RuleStats, 0, len(rules))
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		rs := RuleStats{Rule: r.Name}
+		if s := t.rules[r.Name]; s != nil {
+			rs = *s
+		}
+		out = append(out, rs)
+		seen[r.Name] = true
+	}
+	var extra []RuleStats
+	for name, s := range t.rules {
+		if !seen[name] {
+			extra = append(extra, *s)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Rule < extra[j].Rule })
+	return append(out, extra...)
+}
+
+type ruleSummary struct {
+	Name         string `json:"name"`
+	Action       string `json:"action"`
+	Dest         string `json:"dest"`
+	WebDAVUpload bool   `json:"webdav_upload,omitempty"`
+}
+
+// configSummary is the non-secret part of the config shown by /status.
+type configSummary struct {
+	Path          string        `json:"path"`
+	WatchDir      string        `json:"watch_dir"`
+	SettleMillis  int           `json:"settle_millis"`
+	PollMillis    int           `json:"poll_millis"`
+	Notifications bool          `json:"notifications"`
+	HistoryFile   string        `json:"history_file,omitempty"`
+	WebDAVURL     string        `json:"webdav_url,omitempty"`
+	Rules         []ruleSummary `json:"rules"`
+}
+
+func summarizeConfig(path string, cfg Config) configSummary {
+	s := configSummary{
+		Path:          path,
+		WatchDir:      cfg.WatchDir,
+		SettleMillis:  cfg.SettleMillis,
+		PollMillis:    cfg.PollMillis,
+		Notifications: cfg.Notifications,
+		HistoryFile:   cfg.HistoryFile,
+		WebDAVURL:     cfg.WebDAV.URL,
+		Rules:         make([]ruleSummary, 0, len(cfg.Rules)),
+	}
+	for _, r := range cfg.Rules {
+		s.Rules = append(s.Rules, ruleSummary{Name: r.Name, Action: r.Action, Dest: r.Dest, WebDAVUpload: r.WebDAVUpload})
+	}
+	return s
+}
+
+type statusResponse struct {
+	Paused   bool           `json:"paused"`
+	Pending  int            `json:"pending"` // files queued while paused
+	Held     int            `json:"held"`    // files waiting for free space
+	InFlight []inFlightFile `json:"in_flight"`
+	Config   configSummary  `json:"config"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// apiHandler serves the status and control endpoints:
+//
+//	GET  /status            paused state, in-flight files with their stage, config summary
+//	GET  /operations?limit= most recent operations, newest first
+//	GET  /rules             per-rule statistics
+//	GET  /events            Server-Sent Events stream of pipeline stage transitions
+//	POST /pause, /resume    stop and restart dispatching new files
+//	POST /rescan            process every file currently in the watch dir
+//	POST /reload            re-read the config file
+//
+// Cross-origin POSTs from browsers are refused, so a web page can't pause
+// or reload the daemon through a local port.
+func (d *daemon) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		cfg, _ := d.config()
+		paused, pending := d.pauseState()
+		writeJSON(w, http.StatusOK, statusResponse{
+			Paused:   paused,
+			Pending:  pending,
+			Held:     heldFiles(),
+			InFlight: inFlightFiles(),
+			Config:   summarizeConfig(d.cfgPath, cfg),
+		})
+	})
+
+	mux.HandleFunc("GET /operations", func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+				return
+			}
+			limit = n
+		}
+		writeJSON(w, http.StatusOK, ops.latest(limit))
+	})
+
+	mux.HandleFunc("GET /rules", func(w http.ResponseWriter, _ *http.Request) {
+		cfg, _ := d.config()
+		writeJSON(w, http.StatusOK, ops.stats(cfg.Rules))
+	})
+
+	mux.HandleFunc("GET /events", serveEvents)
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, _ *http.Request) {
+		d.pause()
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, _ *http.Request) {
+		n := d.resume()
+		writeJSON(w, http.StatusOK, map[string]any{"paused": false, "dispatched": n})
+	})
+
+	mux.HandleFunc("POST /rescan", func(w http.ResponseWriter, _ *http.Request) {
+		n, err := d.rescan()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"dispatched": n})
+	})
+
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, _ *http.Request) {
+		if err := d.reload(); err != nil {
+			slog.Error("config reload failed", "event", "reload_failed", "error", err)
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+	})
+
+	return http.NewCrossOriginProtection().Handler(mux)
+}
+
+// localHostOnly refuses requests whose Host isn't "localhost", a loopback
+// address or the host of addr. A web page that rebinds its own domain to
+// 127.0.0.1 still sends that domain as Host, so it can't read file paths from
+// GET /status or /events. Browsers can't reach Unix sockets, so those aren't
+// checked.
+func localHostOnly(addr string, h http.Handler) http.Handler {
+	if strings.HasPrefix(addr, "unix:") {
+		return h
+	}
+	listenHost, _, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]") // no port
+		}
+		ip := net.ParseIP(host)
+		if !strings.EqualFold(host, "localhost") && (ip == nil || !ip.IsLoopback()) &&
+			(listenHost == "" || !strings.EqualFold(host, listenHost)) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q not allowed", r.Host))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// listenAPI opens addr, which is either a TCP address or "unix:/path/to/socket".
+// A stale socket file is replaced and the new one is only accessible to the owner.
+func listenAPI(addr string) (net.Listener, error) {
+	sock, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	sock, err := expandHome(sock)
+	if err != nil {
+		return nil, err
+	}
+	// Replace a socket left by an earlier run, but nothing else: a typo in
+	// api_listen mustn't delete a user's file, and a second daemon mustn't
+	// take over the first one's socket
+	if fi, errStat := os.Lstat(sock); errStat == nil {
+		if fi.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", sock)
+		}
+		c, errDial := net.DialTimeout("unix", sock, time.Second)
+		if errDial == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", sock)
+		}
+		if !connRefused(errDial) {
+			return nil, errDial
+		}
+	}
+
+	// The socket gets the umask's permissions when it is created, so create
+	// it in a private directory, restrict it, and only then rename it into place
+	tmpDir, err := os.MkdirTemp(filepath.Dir(sock), ".downwatch-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	tmp := filepath.Join(tmpDir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if errChmod := os.Chmod(tmp, 0o600); errChmod != nil {
+		_ = l.Close()
+		return nil, errChmod
+	}
+	if errRename := os.Rename(tmp, sock); errRename != nil {
+		_ = l.Close()
+		return nil, errRename
+	}
+	return &socketListener{UnixListener: ul, path: sock}, nil
+}
+
+// socketListener removes its socket file on Close, which net.UnixListener
+// can't do after the socket was renamed.
+type socketListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.UnixListener.Close()
+	_ = os.Remove(l.path)
+	return err
+}
+
+// serveAPI runs the status and control API on addr until the listener fails.
+func serveAPI(addr string, d *daemon) {
+	l, err := listenAPI(addr)
+	if err != nil {
+		slog.Error("api listen failed", "event", "api_failed", "dest", addr, "error", err)
+		return
+	}
+	srv := &http.Server{
+		Handler:           localHostOnly(addr, d.apiHandler()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving api", "event", "api_listening", "dest", addr)
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("api server failed", "event", "api_failed", "dest", addr, "error", err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test opsTracker keeps the newest entries and per-rule totals
+func TestOpsTracker(t *testing.T) {
+	tr := newOpsTracker(3)
+	for i, e := range []HistoryEntry{
+		{Name: "1", Rule: "PDFs", Action: "move", Size: 10},
+		{Name: "2", Rule: "PDFs", Action: "copy", Size: 5},
+		{Name: "3", Rule: "Images", Action: "move", Error: "boom"},
+		{Name: "4", Rule: "PDFs", Action: "delete", Size: 99},
+	} {
+		e.Time = time.Unix(int64(i), 0)
+		tr.add(e)
+	}
+
+	latest := tr.latest(0)
+	if len(latest) != 3 || latest[0].Name != "4" || latest[2].Name != "2" {
+		t.Errorf("latest(0) = %v, want entries 4,3,2", latest)
+	}
+	if got := tr.latest(1); len(got) != 1 || got[0].Name != "4" {
+		t.Errorf("latest(1) = %v, want entry 4", got)
+	}
+
+	stats := tr.stats([]Rule{{Name: "Videos"}, {Name: "PDFs"}})
+	if len(stats) != 3 {
+		t.Fatalf("stats = %v, want Videos, PDFs, Images", stats)
+	}
+	if stats[0].Rule != "Videos" || stats[0].Processed != 0 {
+		t.Errorf("stats[0] = %+v, want empty Videos", stats[0])
+	}
+	if stats[1].Rule != "PDFs" || stats[1].Processed != 3 || stats[1].Bytes != 15 {
+		t.Errorf("stats[1] = %+v, want PDFs processed=3 bytes=15", stats[1])
+	}
+	if stats[2].Rule != "Images" || stats[2].Failed != 1 {
+		t.Errorf("stats[2] = %+v, want Images failed=1", stats[2])
+	}
+}
+
+func newTestDaemon(t *testing.T) (*daemon, string) {
+	t.Helper()
+	dir := t.TempDir()
+	watch := filepath.Join(dir, "watch")
+	if err := os.Mkdir(watch, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "config.yaml")
+	cfgYAML := "watch_dir: " + watch + "\nhistory_file: \"\"\nrules:\n  - name: PDFs\n    extensions: [pdf]\n    dest: " + filepath.Join(dir, "docs") + "\n"
+	if err := os.WriteFile(cfgPath, []byte(cfgYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return newDaemon(cfgPath, cfg), cfgPath
+}
+
+func apiDo(t *testing.T, h http.Handler, method, path string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// Test /status reports in-flight files and config, and pause queues rescanned files
+func TestAPIStatusPauseRescan(t *testing.T) {
+	d, cfgPath := newTestDaemon(t)
+	h := d.apiHandler()
+
+	state := newFileState()
+	state.setStage(stageStabilizing)
+	processing.Store("/tmp/api-test.iso", state)
+	defer processing.Delete("/tmp/api-test.iso")
+
+	var st statusResponse
+	if code := apiDo(t, h, "GET", "/status", &st); code != http.StatusOK {
+		t.Fatalf("GET /status = %d", code)
+	}
+	found := false
+	for _, f := range st.InFlight {
+		if f.Path == "/tmp/api-test.iso" && f.Stage == stageStabilizing {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("in_flight = %+v, want /tmp/api-test.iso stabilizing", st.InFlight)
+	}
+	if st.Config.Path != cfgPath || len(st.Config.Rules) != 1 || st.Config.Rules[0].Name != "PDFs" {
+		t.Errorf("config summary = %+v", st.Config)
+	}
+
+	if code := apiDo(t, h, "POST", "/pause", nil); code != http.StatusOK {
+		t.Fatalf("POST /pause = %d", code)
+	}
+	cfg, _ := d.config()
+	for _, n := range []string{"a.pdf", "b.pdf"} {
+		if err := os.WriteFile(filepath.Join(cfg.WatchDir, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rescan map[string]int
+	if code := apiDo(t, h, "POST", "/rescan", &rescan); code != http.StatusOK || rescan["dispatched"] != 2 {
+		t.Fatalf("POST /rescan = %d %v, want 2 dispatched", code, rescan)
+	}
+	if code := apiDo(t, h, "GET", "/status", &st); code != http.StatusOK || !st.Paused || st.Pending != 2 {
+		t.Errorf("after pause+rescan: paused=%v pending=%d, want true 2", st.Paused, st.Pending)
+	}
+
+	if code := apiDo(t, h, "GET", "/pause", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause = %d, want 405", code)
+	}
+}
+
+// Test /reload picks up config changes and rejects broken configs
+func TestAPIReload(t *testing.T) {
+	d, cfgPath := newTestDaemon(t)
+	h := d.apiHandler()
+	cfg, _ := d.config()
+
+	updated := "watch_dir: " + cfg.WatchDir + "\nhistory_file: \"\"\nsettle_millis: 5000\nrules: []\n"
+	if err := os.WriteFile(cfgPath, []byte(updated), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := apiDo(t, h, "POST", "/reload", nil); code != http.StatusOK {
+		t.Fatalf("POST /reload = %d", code)
+	}
+	if cfg, _ = d.config(); cfg.SettleMillis != 5000 || len(cfg.Rules) != 0 {
+		t.Errorf("after reload settle=%d rules=%d, want 5000 0", cfg.SettleMillis, len(cfg.Rules))
+	}
+
+	if err := os.WriteFile(cfgPath, []byte("rules:\n  - name: bad\n    action: shred\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var resp map[string]string
+	if code := apiDo(t, h, "POST", "/reload", &resp); code != http.StatusUnprocessableEntity || resp["error"] == "" {
+		t.Errorf("POST /reload with bad config = %d %v, want 422 with error", code, resp)
+	}
+	if cfg, _ = d.config(); cfg.SettleMillis != 5000 {
+		t.Errorf("bad reload replaced config: settle=%d", cfg.SettleMillis)
+	}
+}
+
+// Test control endpoints refuse cross-origin requests from browsers
+func TestAPICrossOrigin(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	h := d.apiHandler()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"cross-site fetch", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"foreign origin", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"same origin", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"curl", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.resume()
+			req := httptest.NewRequest("POST", "/pause", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("POST /pause = %d, want %d", rec.Code, tt.want)
+			}
+			if paused, _ := d.pauseState(); paused != (tt.want == http.StatusOK) {
+				t.Errorf("paused = %v after %d response", paused, rec.Code)
+			}
+		})
+	}
+}
+
+// Test a TCP API only answers requests addressed to a local host name
+func TestLocalHostOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		addr, host string
+		want       int
+	}{
+		{"127.0.0.1:7878", "127.0.0.1:7878", http.StatusOK},
+		{"127.0.0.1:7878", "localhost:7878", http.StatusOK},
+		{"[::1]:7878", "[::1]:7878", http.StatusOK},
+		{"127.0.0.1:7878", "[::1]", http.StatusOK},
+		{"192.168.1.5:7878", "192.168.1.5:7878", http.StatusOK},
+		{"127.0.0.1:7878", "evil.example:7878", http.StatusForbidden},
+		{"127.0.0.1:7878", "192.168.1.5:7878", http.StatusForbidden},
+		{":7878", "rebound.example", http.StatusForbidden},
+		{"unix:/run/downwatch.sock", "downwatch", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		localHostOnly(tt.addr, ok).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("listen %s, Host %s: %d, want %d", tt.addr, tt.host, rec.Code, tt.want)
+		}
+	}
+}
+
+// Test listenAPI on a Unix socket replaces a stale socket
+func TestListenAPIUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "downwatch.sock")
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close() // left behind, as after a crash
+
+	l, err := listenAPI("unix:" + sock)
+	if err != nil {
+		t.Fatalf("listenAPI: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+// Test listenAPI won't delete a regular file at the socket path
+func TestListenAPIUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	writeFile(t, path, "important")
+	if l, err := listenAPI("unix:" + path); err == nil {
+		_ = l.Close()
+		t.Fatal("listenAPI replaced a regular file")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "important" {
+		t.Errorf("file = %q (err %v), want it untouched", b, err)
+	}
+}
+
+// Test listenAPI won't take over a socket another process is serving
+func TestListenAPIUnixInUse(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "downwatch.sock")
+	first, err := listenAPI("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+
+	if l, err := listenAPI("unix:" + sock); err == nil {
+		_ = l.Close()
+		t.Fatal("listenAPI replaced a socket in use")
+	}
+	if c, err := net.Dial("unix", sock); err != nil {
+		t.Errorf("first listener's socket gone: %v", err)
+	} else {
+		_ = c.Close()
+	}
+}
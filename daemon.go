@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// Processing stages reported for in-flight files
+const (
+	stageDetected    = "detected"
+	stageStabilizing = "stabilizing"
+	stageMatching    = "matching"
+	stageFiling      = "filing"
+	stageUploading   = "uploading"
+)
+
+// fileState is the processing map entry for a file being handled.
+type fileState struct {
+	started time.Time
+	touched chan struct{} // the watcher saw the file change; restarts the settle window
+	mu      sync.Mutex
+	stage   string
+}
+
+func newFileState() *fileState {
+	return &fileState{started: time.Now(), touched: make(chan struct{}, 1), stage: stageDetected}
+}
+
+// touchFile tells the stability wait of an in-flight file that it changed.
+func touchFile(path string) {
+	if v, ok := processing.Load(path); ok {
+		select {
+		case v.(*fileState).touched <- struct{}{}:
+		default: // a wakeup is already pending
+		}
+	}
+}
+
+func (s *fileState) setStage(stage string) {
+	s.mu.Lock()
+	s.stage = stage
+	s.mu.Unlock()
+}
+
+func (s *fileState) getStage() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stage
+}
+
+type inFlightFile struct {
+	Path  string    `json:"path"`
+	Stage string    `json:"stage"`
+	Since time.Time `json:"since"`
+}
+
+// inFlightFiles lists the processing map, oldest first.
+func inFlightFiles() []inFlightFile {
+	var files []inFlightFile
+	processing.Range(func(k, v any) bool {
+		f := inFlightFile{Path: k.(string)}
+		if st, ok := v.(*fileState); ok {
+			f.Stage = st.getStage()
+			f.Since = st.started
+		}
+		files = append(files, f)
+		return true
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Since.Before(files[j].Since) })
+	return files
+}
+
+// daemon holds the state of the running watcher that the control API can change.
+type daemon struct {
+	cfgPath string
+	watcher dirWatcher
+
+	mu      sync.Mutex
+	cfg     Config
+	dav     *gowebdav.Client
+	paused  bool
+	pending map[string]bool // path -> skipStabilityCheck, queued while paused
+}
+
+func newDaemon(cfgPath string, cfg Config) *daemon {
+	d := &daemon{cfgPath: cfgPath, cfg: cfg, pending: make(map[string]bool)}
+	if cfg.WebDAV.URL != "" {
+		d.dav = davClient(cfg.WebDAV)
+	}
+	return d
+}
+
+// config returns the current configuration and DAV client.
+func (d *daemon) config() (Config, *gowebdav.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg, d.dav
+}
+
+// dispatch hands path to handleFile, or queues it while processing is paused.
+// Files that don't stabilize in time are dispatched again.
+func (d *daemon) dispatch(path string, skipStabilityCheck bool) {
+	if run := d.job(path, skipStabilityCheck); run != nil {
+		go run()
+	}
+}
+
+// job returns a function that runs handleFile on path with the current
+// config, or nil if path is ignored, gone, or was queued because processing
+// is paused. Ignored files are turned away here, before they take a
+// goroutine or a processing slot.
+func (d *daemon) job(path string, skipStabilityCheck bool) func() {
+	cfg, _ := d.config()
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	if reason := cfg.ignoreReason(path, fi); reason != "" {
+		slog.Debug("skip (ignored)", "event", "skip_ignored", "file", filepath.Base(path), "reason", reason)
+		if reason == ignoreEmpty || reason == ignoreYoung {
+			d.deferFile(path, reason, fi, time.Duration(cfg.Ignore.YoungerSec)*time.Second)
+		}
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused {
+		d.pending[path] = d.pending[path] || skipStabilityCheck
+		return nil
+	}
+	cfg, dav := d.cfg, d.dav
+	opts := handleOptions{skipStability: skipStabilityCheck, watched: d.watcher != nil}
+	return func() {
+		res := handleFile(path, cfg, dav, opts)
+		// Still changing after max_wait_seconds: start waiting again rather
+		// than leaving it in the watch dir until the next event
+		if errors.Is(res.Err, errNotStable) {
+			slog.Info("re-queued (not stable yet)", "event", "requeued", "file", filepath.Base(path))
+			d.dispatch(path, false)
+		}
+	}
+}
+
+func (d *daemon) pause() {
+	d.mu.Lock()
+	d.paused = true
+	d.mu.Unlock()
+	slog.Info("processing paused", "event", "paused")
+}
+
+// resume restarts processing and dispatches everything queued while paused.
+// It returns the number of queued files.
+func (d *daemon) resume() int {
+	d.mu.Lock()
+	d.paused = false
+	pending := d.pending
+	d.pending = make(map[string]bool)
+	d.mu.Unlock()
+
+	slog.Info("processing resumed", "event", "resumed", "files", len(pending))
+	for path, skip := range pending {
+		d.dispatch(path, skip)
+	}
+	return len(pending)
+}
+
+// pauseState reports whether processing is paused and how many files are queued.
+func (d *daemon) pauseState() (paused bool, pending int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused, len(d.pending)
+}
+
+// rescan dispatches every file in the watch directory through the normal
+// pipeline, including stability checks. Files already in flight are skipped
+// by handleFile. It returns the number of files dispatched.
+func (d *daemon) rescan() (int, error) {
+	cfg, _ := d.config()
+	entries, err := os.ReadDir(cfg.WatchDir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			d.dispatch(filepath.Join(cfg.WatchDir, e.Name()), false)
+			n++
+		}
+	}
+	slog.Info("rescan", "event", "rescan", "dest", cfg.WatchDir, "files", n)
+	return n, nil
+}
+
+// reconcile dispatches the files in the watch directory that a rule matches
+// but that aren't in flight or held, i.e. ones whose events the watcher
+// missed. Unmatched files are left alone so they aren't retried forever.
+// It returns the number of files dispatched.
+func (d *daemon) reconcile() (int, error) {
+	cfg, _ := d.config()
+	entries, err := os.ReadDir(cfg.WatchDir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		path := filepath.Join(cfg.WatchDir, e.Name())
+		if e.IsDir() {
+			continue
+		}
+		if fi, errInfo := e.Info(); errInfo != nil || cfg.ignoreReason(path, fi) != "" {
+			continue
+		}
+		if _, busy := processing.Load(path); busy {
+			continue
+		}
+		if _, isHeld := held.Load(path); isHeld {
+			continue // retryHeld's job
+		}
+		if chooseRule(path, cfg.Rules) == nil {
+			continue
+		}
+		d.dispatch(path, false)
+		n++
+	}
+	if n > 0 {
+		slog.Info("reconcile found missed files", "event", "reconcile", "dest", cfg.WatchDir, "files", n)
+	}
+	return n, nil
+}
+
+// reconcileEvery runs reconcile every interval.
+func (d *daemon) reconcileEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := d.reconcile(); err != nil {
+			slog.Error("reconcile failed", "event", "reconcile_failed", "error", err)
+		}
+	}
+}
+
+// reload re-reads the config file and swaps it in for newly dispatched files.
+// Files already in flight finish with the config they started with.
+// Listen addresses (metrics_listen, api_listen), journal_file,
+// rescan_seconds and the watch backend only change on restart.
+func (d *daemon) reload() error {
+	cfg, err := loadConfig(d.cfgPath)
+	if err != nil {
+		return err
+	}
+	if fi, errStat := os.Stat(cfg.WatchDir); errStat != nil || !fi.IsDir() {
+		return fmt.Errorf("watch_dir is not a directory: %s", cfg.WatchDir)
+	}
+
+	d.mu.Lock()
+	old := d.cfg
+	if d.watcher != nil && cfg.WatchDir != old.WatchDir {
+		if errAdd := d.watcher.Add(cfg.WatchDir); errAdd != nil {
+			d.mu.Unlock()
+			return errAdd
+		}
+		_ = d.watcher.Remove(old.WatchDir)
+	}
+	d.cfg = cfg
+	d.dav = nil
+	if cfg.WebDAV.URL != "" {
+		d.dav = davClient(cfg.WebDAV)
+	}
+	d.mu.Unlock()
+
+	setupLogging(cfg)
+	if cfg.MetricsListen != old.MetricsListen || cfg.APIListen != old.APIListen || cfg.JournalFile != old.JournalFile || cfg.RescanSec != old.RescanSec ||
+		cfg.WatchBackend != old.WatchBackend || cfg.WatchPollSec != old.WatchPollSec {
+		slog.Warn("listen address, journal_file, rescan_seconds and watch backend changes take effect on restart", "event", "reload_partial")
+	}
+	slog.Info("config reloaded", "event", "reloaded", "rules", len(cfg.Rules), "dest", cfg.WatchDir)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test reconcile dispatches only matching files that aren't in flight, held or ignored
+func TestReconcile(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	d.cfg.SettleMillis, d.cfg.PollMillis = 50, 10
+	watch := d.cfg.WatchDir
+	for _, n := range []string{"missed.pdf", "held.pdf", "busy.pdf", "notes.xyz", "movie.part"} {
+		writeFile(t, filepath.Join(watch, n), "x")
+	}
+	heldPath, busyPath := filepath.Join(watch, "held.pdf"), filepath.Join(watch, "busy.pdf")
+	held.Store(heldPath, time.Now())
+	defer held.Delete(heldPath)
+	processing.Store(busyPath, newFileState())
+	defer processing.Delete(busyPath)
+
+	n, err := d.reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("reconcile() = %d, want 1", n)
+	}
+	dest := filepath.Join(filepath.Dir(watch), "docs", "missed.pdf")
+	for deadline := time.Now().Add(5 * time.Second); !exists(dest); {
+		if time.Now().After(deadline) {
+			t.Fatal("missed.pdf was not filed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, n := range []string{"held.pdf", "busy.pdf", "notes.xyz", "movie.part"} {
+		if !exists(filepath.Join(watch, n)) {
+			t.Errorf("%s was touched", n)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// destVars are the placeholders a rule's dest may contain, e.g.
+// "~/Work/Attachments/{origin.host}" or "~/Pictures/{exif.year}/{exif.month}",
+// with how to get each one's value for a file. A value that is unknown for
+// the file is replaced with "unknown".
+var destVars = map[string]func(m *fileMeta) string{
+	"origin.host":   func(m *fileMeta) string { return hostOf(m.origin().url) },
+	"referrer.host": func(m *fileMeta) string { return hostOf(m.origin().referrer) },
+	// Dates are when the photo was taken, or the file's mtime without EXIF
+	"exif.year":  func(m *fileMeta) string { return formatTaken(m, "2006") },
+	"exif.month": func(m *fileMeta) string { return formatTaken(m, "01") },
+	"exif.day":   func(m *fileMeta) string { return formatTaken(m, "02") },
+	"exif.make": func(m *fileMeta) string {
+		if x := m.exif(); x != nil {
+			return x.Make
+		}
+		return ""
+	},
+	"exif.model": func(m *fileMeta) string {
+		if x := m.exif(); x != nil {
+			return x.Model
+		}
+		return ""
+	},
+}
+
+func formatTaken(m *fileMeta, layout string) string {
+	if t := m.takenTime(); !t.IsZero() {
+		return t.Format(layout)
+	}
+	return ""
+}
+
+var destVarRe = regexp.MustCompile(`\{([a-z0-9_.]+)\}`)
+
+// validateDest checks that dest only uses known placeholders.
+func validateDest(dest string) error {
+	for _, m := range destVarRe.FindAllStringSubmatch(dest, -1) {
+		if _, ok := destVars[m[1]]; !ok {
+			names := make([]string, 0, len(destVars))
+			for n := range destVars {
+				names = append(names, "{"+n+"}")
+			}
+			slices.Sort(names)
+			return fmt.Errorf("unknown placeholder %s in dest (known: %s)", m[0], strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// expandDest fills in the placeholders in dest for the file at path.
+func expandDest(dest, path string) string {
+	meta := newFileMeta(path)
+	return destVarRe.ReplaceAllStringFunc(dest, func(m string) string {
+		get, ok := destVars[m[1:len(m)-1]]
+		if !ok {
+			return m
+		}
+		return pathSafe(get(meta))
+	})
+}
+
+// pathSafe turns a value into a single path element: separators are
+// replaced, and empty or dot-only values become "unknown".
+func pathSafe(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(v))
+	if strings.Trim(v, ".") == "" {
+		return "unknown"
+	}
+	return v
+}
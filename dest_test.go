@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Test validateDest accepts known placeholders only
+func TestValidateDest(t *testing.T) {
+	tests := []struct {
+		dest    string
+		wantErr bool
+	}{
+		{"~/Documents", false},
+		{"~/Work/{origin.host}", false},
+		{"~/Work/{referrer.host}/{origin.host}", false},
+		{"~/Pictures/{exif.year}/{exif.month}", false},
+		{"~/Work/{origin.path}", true},
+		{"~/Work/{Origin.Host}", false}, // not a placeholder: left as is
+	}
+	for _, tt := range tests {
+		if err := validateDest(tt.dest); (err != nil) != tt.wantErr {
+			t.Errorf("validateDest(%q) error = %v, wantErr %v", tt.dest, err, tt.wantErr)
+		}
+	}
+}
+
+// Test expandDest falls back to "unknown" without origin attributes
+func TestExpandDestNoOrigin(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.pdf")
+	writeFile(t, p, "x")
+	if got, want := expandDest("/work/{origin.host}/in", p), "/work/unknown/in"; got != want {
+		t.Errorf("expandDest() = %q, want %q", got, want)
+	}
+}
+
+// Test pathSafe keeps values to one path element
+func TestPathSafe(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"jira.example.com", "jira.example.com"},
+		{"", "unknown"},
+		{"..", "unknown"},
+		{"a/../b", "a_.._b"},
+		{`c:\x`, "c:_x"},
+	}
+	for _, tt := range tests {
+		if got := pathSafe(tt.in); got != tt.want {
+			t.Errorf("pathSafe(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digest coalesces successful filings into one desktop notification per
+// window, so dropping a folder of 300 photos doesn't produce 300 popups.
+type digest struct {
+	send func(notification)
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	cfg      Config // of the latest filing, for the undo button
+	filed    []HistoryEntry
+	failures int
+}
+
+var notifyDigest = &digest{send: sendNotification}
+
+// add queues a filed entry and starts the window if it isn't running.
+func (d *digest) add(cfg Config, e HistoryEntry, window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cfg = cfg
+	d.filed = append(d.filed, e)
+	d.start(window)
+}
+
+// addFailure counts a failure in the next digest; the failure itself is
+// notified immediately by the caller.
+func (d *digest) addFailure(window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures++
+	d.start(window)
+}
+
+// start arms the flush timer. Caller holds d.mu.
+func (d *digest) start(window time.Duration) {
+	if d.timer == nil {
+		d.timer = time.AfterFunc(window, d.flush)
+	}
+}
+
+func (d *digest) flush() {
+	d.mu.Lock()
+	cfg, filed, failures := d.cfg, d.filed, d.failures
+	d.filed, d.failures, d.timer = nil, 0, nil
+	d.mu.Unlock()
+
+	if len(filed) == 0 {
+		return // only failures, which were already notified
+	}
+	d.send(digestNotification(cfg, filed, failures))
+}
+
+// digestNotification summarizes filed entries, e.g. "Moved 298 files to ~/Pictures, 2 failures".
+// A single file gets the same notification (and buttons) it would have had on its own.
+func digestNotification(cfg Config, filed []HistoryEntry, failures int) notification {
+	if len(filed) == 1 && failures == 0 {
+		return filingNotification(cfg, filed[0])
+	}
+
+	type group struct {
+		action, dir string
+	}
+	var order []group
+	counts := make(map[group]int)
+	for _, e := range filed {
+		g := group{e.Action, filepath.Dir(e.Dest)}
+		if counts[g] == 0 {
+			order = append(order, g)
+		}
+		counts[g]++
+	}
+
+	parts := make([]string, 0, len(order)+1)
+	for _, g := range order {
+		verb := "Moved"
+		if g.action == "copy" {
+			verb = "Copied"
+		}
+		if len(parts) > 0 {
+			verb = strings.ToLower(verb)
+		}
+		noun := "files"
+		if counts[g] == 1 {
+			noun = "file"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d %s to %s", verb, counts[g], noun, tildePath(g.dir)))
+	}
+	switch failures {
+	case 0:
+	case 1:
+		parts = append(parts, "1 failure")
+	default:
+		parts = append(parts, fmt.Sprintf("%d failures", failures))
+	}
+
+	n := notification{Title: "downwatch", Message: strings.Join(parts, ", ")}
+	if len(order) == 1 {
+		dir := order[0].dir
+		n.Actions = []notifyAction{{Label: "Open folder", Run: func() { openFolder(dir) }}}
+	}
+	return n
+}
+
+// filingNotification is the notification for one moved or copied file under cfg.
+func filingNotification(cfg Config, e HistoryEntry) notification {
+	verb := "Moved"
+	if e.Action == "copy" {
+		verb = "Copied"
+	}
+	destDir := filepath.Dir(e.Dest)
+	return notification{
+		Title:   "downwatch",
+		Message: fmt.Sprintf("%s %s to %s", verb, e.Name, tildePath(destDir)),
+		Actions: filingActions(e.Action, e.Source, e.Dest, destDir, cfg.undoOptions(e)),
+	}
+}
+
+// undoOptions are the copy options for undoing e: those of the rule that
+// filed it, so a move back across filesystems keeps what the move kept.
+func (cfg Config) undoOptions(e HistoryEntry) copyOptions {
+	return cfg.copyOptions(findRule(cfg.Rules, e.Rule))
+}
+
+// tildePath shortens paths under the home directory to ~/...
+func tildePath(p string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return p
+	}
+	if p == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(p, home+string(filepath.Separator)); ok {
+		return "~/" + filepath.ToSlash(rest)
+	}
+	return p
+}
+
+// notifyOperation shows the desktop notification for a recorded operation.
+// Failures are shown immediately; moves and copies are batched into a digest
+// when notify_digest_seconds is set. Duplicate handling is not notified.
+func notifyOperation(cfg Config, e HistoryEntry) {
+	if !cfg.Notifications {
+		return
+	}
+	if r := findRule(cfg.Rules, e.Rule); r != nil && r.Notify != nil && !*r.Notify {
+		return
+	}
+	window := time.Duration(cfg.NotifyDigest) * time.Second
+
+	if e.Error != "" {
+		sendNotification(notification{Title: "downwatch", Message: e.summary()})
+		if window > 0 {
+			notifyDigest.addFailure(window)
+		}
+		return
+	}
+	if e.Action != "move" && e.Action != "copy" {
+		return
+	}
+	if window <= 0 {
+		sendNotification(filingNotification(cfg, e))
+		return
+	}
+	notifyDigest.add(cfg, e, window)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test many filings in one window produce a single summary
+func TestDigestCoalesces(t *testing.T) {
+	sent := make(chan notification, 4)
+	d := &digest{send: func(n notification) { sent <- n }}
+
+	for i := 0; i < 298; i++ {
+		d.add(Config{}, HistoryEntry{Name: "img.jpg", Action: "move", Dest: "/pics/img.jpg"}, 50*time.Millisecond)
+	}
+	d.addFailure(50 * time.Millisecond)
+	d.addFailure(50 * time.Millisecond)
+
+	select {
+	case n := <-sent:
+		if want := "Moved 298 files to /pics, 2 failures"; n.Message != want {
+			t.Errorf("digest message = %q, want %q", n.Message, want)
+		}
+		if len(n.Actions) != 1 || n.Actions[0].Label != "Open folder" {
+			t.Errorf("digest actions = %v, want Open folder", n.Actions)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("digest not sent")
+	}
+	select {
+	case n := <-sent:
+		t.Errorf("unexpected second notification %q", n.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test a window holding only failures sends nothing more
+func TestDigestOnlyFailures(t *testing.T) {
+	sent := make(chan notification, 1)
+	d := &digest{send: func(n notification) { sent <- n }}
+	d.addFailure(10 * time.Millisecond)
+
+	select {
+	case n := <-sent:
+		t.Errorf("unexpected digest %q", n.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test digest message wording for mixed and single filings
+func TestDigestNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		filed    []HistoryEntry
+		failures int
+		want     string
+		actions  int
+	}{
+		{
+			name:    "single file keeps per-file notification",
+			filed:   []HistoryEntry{{Name: "a.pdf", Action: "copy", Source: "/dl/a.pdf", Dest: "/docs/a.pdf"}},
+			want:    "Copied a.pdf to /docs",
+			actions: 2,
+		},
+		{
+			name:     "single file with failure",
+			filed:    []HistoryEntry{{Name: "a.pdf", Action: "move", Dest: "/docs/a.pdf"}},
+			failures: 1,
+			want:     "Moved 1 file to /docs, 1 failure",
+			actions:  1,
+		},
+		{
+			name: "several destinations",
+			filed: []HistoryEntry{
+				{Action: "move", Dest: "/pics/1.jpg"},
+				{Action: "move", Dest: "/pics/2.jpg"},
+				{Action: "copy", Dest: "/videos/1.mp4"},
+			},
+			want:    "Moved 2 files to /pics, copied 1 file to /videos",
+			actions: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := digestNotification(Config{}, tt.filed, tt.failures)
+			if n.Message != tt.want {
+				t.Errorf("message = %q, want %q", n.Message, tt.want)
+			}
+			if len(n.Actions) != tt.actions {
+				t.Errorf("got %d actions, want %d", len(n.Actions), tt.actions)
+			}
+		})
+	}
+}
+
+// Test tildePath shortens home-relative paths
+func TestTildePath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	if got := tildePath(filepath.Join(home, "Pictures")); got != "~/Pictures" {
+		t.Errorf("tildePath(home/Pictures) = %q", got)
+	}
+	if got := tildePath(home + "x/Pictures"); got != home+"x/Pictures" {
+		t.Errorf("tildePath shortened a sibling of home: %q", got)
+	}
+	if got := tildePath("/srv/data"); got != "/srv/data" {
+		t.Errorf("tildePath(/srv/data) = %q", got)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type EmailConfig struct {
+	Host          string   `yaml:"host"`            // SMTP server; empty disables email
+	Port          int      `yaml:"port"`            // default 587 for starttls, 465 for tls, 25 for none
+	Security      string   `yaml:"security"`        // "starttls" (default), "tls" (implicit TLS) or "none"
+	Username      string   `yaml:"username"`        // AUTH PLAIN when set
+	Password      string   `yaml:"password"`        //
+	SkipTLSVerify bool     `yaml:"skip_tls_verify"` // accept self-signed server certificates
+	From          string   `yaml:"from"`            // sender address
+	To            []string `yaml:"to"`              // recipients
+	Mode          string   `yaml:"mode"`            // "digest" (default): daily report; "event": one email per event
+	Events        []string `yaml:"events"`          // event mode: "success", "duplicate", "failure"; default all
+	DigestAt      string   `yaml:"digest_at"`       // digest mode: local time "HH:MM" to send the report; default "18:00"
+	SendEmpty     bool     `yaml:"send_empty"`      // digest mode: send a report even when nothing happened
+	Subject       string   `yaml:"subject"`         // text/template for the subject line
+	Template      string   `yaml:"template"`        // text/template for the body
+	TemplateFile  string   `yaml:"template_file"`   // read the body template from this file instead
+	TimeoutSec    int      `yaml:"timeout_sec"`     // connection timeout; default 30
+}
+
+const defaultEmailSubjectDigest = `downwatch on {{.Host}}: {{len .Filed}} filed{{if .Failed}}, {{len .Failed}} failed{{end}}`
+
+const defaultEmailSubjectEvent = `downwatch on {{.Host}}: {{.Entry.Summary}}`
+
+const defaultEmailBodyDigest = `downwatch report for {{.Host}}
+{{.Start.Format "2006-01-02 15:04"}} to {{.End.Format "2006-01-02 15:04"}}
+{{if .Failed}}
+Failed ({{len .Failed}}):
+{{range .Failed}}  {{.Time.Format "15:04"}}  {{.Name}}  [{{.Rule}}] {{.Action}}: {{.Error}}
+{{end}}{{end}}
+Filed ({{len .Filed}}):
+{{range .Filed}}  {{.Time.Format "15:04"}}  {{.Action}}  {{.Name}}  -> {{.Dest}}  [{{.Rule}}]
+{{else}}  nothing
+{{end}}`
+
+const defaultEmailBodyEvent = `{{.Entry.Summary}}
+
+Time:   {{.Entry.Time.Format "2006-01-02 15:04:05"}}
+Rule:   {{.Entry.Rule}}
+Action: {{.Entry.Action}}
+From:   {{.Entry.Source}}
+To:     {{.Entry.Dest}}
+Size:   {{.Entry.Size}} bytes
+{{if .Entry.Error}}Error:  {{.Entry.Error}}
+{{end}}`
+
+// emailData is the template context. Digest reports fill Start, End, Entries,
+// Filed and Failed; per-event emails fill Entry.
+type emailData struct {
+	Host    string
+	Start   time.Time
+	End     time.Time
+	Entries []emailEntry
+	Filed   []emailEntry // successful move, copy, delete and skip
+	Failed  []emailEntry
+	Entry   emailEntry
+}
+
+// emailEntry exposes a HistoryEntry plus its one-line summary to templates.
+type emailEntry struct {
+	HistoryEntry
+	Summary string
+}
+
+// normalizeEmail validates the email config, fills in defaults and parses the templates.
+func normalizeEmail(c *EmailConfig) error {
+	if c.Host == "" {
+		return nil
+	}
+	c.Security = strings.ToLower(strings.TrimSpace(c.Security))
+	switch c.Security {
+	case "", "starttls":
+		c.Security = "starttls"
+		if c.Port == 0 {
+			c.Port = 587
+		}
+	case "tls":
+		if c.Port == 0 {
+			c.Port = 465
+		}
+	case "none":
+		if c.Port == 0 {
+			c.Port = 25
+		}
+	default:
+		return fmt.Errorf("email: invalid security %q (want starttls, tls or none)", c.Security)
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return errors.New("email: from and to are required")
+	}
+	c.Mode = strings.ToLower(strings.TrimSpace(c.Mode))
+	switch c.Mode {
+	case "", "digest":
+		c.Mode = "digest"
+		if c.DigestAt == "" {
+			c.DigestAt = "18:00"
+		}
+		if _, _, err := parseClock(c.DigestAt); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	case "event":
+		if err := normalizeWebhookEvents(c.Events); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	default:
+		return fmt.Errorf("email: invalid mode %q (want digest or event)", c.Mode)
+	}
+	if c.TemplateFile != "" {
+		p, err := expandHome(c.TemplateFile)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+		c.Template = string(b)
+	}
+	if c.Subject == "" {
+		c.Subject = defaultEmailSubjectDigest
+		if c.Mode == "event" {
+			c.Subject = defaultEmailSubjectEvent
+		}
+	}
+	if c.Template == "" {
+		c.Template = defaultEmailBodyDigest
+		if c.Mode == "event" {
+			c.Template = defaultEmailBodyEvent
+		}
+	}
+	if _, err := template.New("subject").Parse(c.Subject); err != nil {
+		return fmt.Errorf("email subject: %w", err)
+	}
+	if _, err := template.New("body").Parse(c.Template); err != nil {
+		return fmt.Errorf("email template: %w", err)
+	}
+	if c.TimeoutSec <= 0 {
+		c.TimeoutSec = 30
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM".
+func parseClock(s string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// nextClock returns the next time after now that the local clock reads hour:minute.
+func nextClock(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func renderEmail(c EmailConfig, data emailData) (subject, body string, err error) {
+	var buf bytes.Buffer
+	st, err := template.New("subject").Parse(c.Subject)
+	if err != nil {
+		return "", "", err
+	}
+	if err = st.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	subject = strings.TrimSpace(strings.ReplaceAll(buf.String(), "\n", " "))
+
+	buf.Reset()
+	bt, err := template.New("body").Parse(c.Template)
+	if err != nil {
+		return "", "", err
+	}
+	if err = bt.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return subject, buf.String(), nil
+}
+
+func newEmailEntry(e HistoryEntry) emailEntry {
+	return emailEntry{HistoryEntry: e, Summary: e.summary()}
+}
+
+// buildMessage assembles an RFC 5322 plain-text message.
+func buildMessage(c EmailConfig, subject, body string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", c.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(c.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// sendEmail delivers one message using the configured transport security.
+func sendEmail(c EmailConfig, subject, body string) error {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	timeout := time.Duration(c.TimeoutSec) * time.Second
+	// #nosec G402 - InsecureSkipVerify is intentional when user configures skip_tls_verify
+	tlsCfg := &tls.Config{ServerName: c.Host, InsecureSkipVerify: c.SkipTLSVerify}
+
+	var conn net.Conn
+	var err error
+	if c.Security == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	if c.Security == "starttls" {
+		if err = client.StartTLS(tlsCfg); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, rcpt := range c.To {
+		if err = client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(buildMessage(c, subject, body, time.Now())); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailReport collects operations for the next digest email.
+type emailReport struct {
+	mu      sync.Mutex
+	start   time.Time
+	entries []HistoryEntry
+}
+
+var dailyReport = &emailReport{start: time.Now()}
+
+func (r *emailReport) add(e HistoryEntry) {
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// take returns the collected entries and the period they cover, and starts a new period.
+func (r *emailReport) take(now time.Time) (entries []HistoryEntry, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries, start = r.entries, r.start
+	r.entries, r.start = nil, now
+	return entries, start
+}
+
+func digestData(entries []HistoryEntry, start, end time.Time) emailData {
+	host, _ := os.Hostname()
+	data := emailData{Host: host, Start: start, End: end}
+	for _, e := range entries {
+		ee := newEmailEntry(e)
+		data.Entries = append(data.Entries, ee)
+		if e.Error != "" {
+			data.Failed = append(data.Failed, ee)
+		} else {
+			data.Filed = append(data.Filed, ee)
+		}
+	}
+	return data
+}
+
+// sendDigest emails the report collected since the previous one.
+func sendDigest(c EmailConfig, now time.Time) {
+	entries, start := dailyReport.take(now)
+	if len(entries) == 0 && !c.SendEmpty {
+		return
+	}
+	subject, body, err := renderEmail(c, digestData(entries, start, now))
+	if err == nil {
+		err = sendEmail(c, subject, body)
+	}
+	if err != nil {
+		slog.Error("email report failed", "event", "email_failed", "dest", strings.Join(c.To, ","), "error", err)
+		return
+	}
+	slog.Info("email report sent", "event", "email_sent", "dest", strings.Join(c.To, ","), "files", len(entries))
+}
+
+// runEmailDigest sends the daily report at digest_at, forever. The config is
+// re-read for every report so reloads apply, including ones that turn the
+// digest on or off; a new digest_at takes effect after the report already
+// scheduled.
+func runEmailDigest(current func() Config) {
+	for {
+		hour, minute, err := parseClock(current().Email.DigestAt) // validated by normalizeEmail
+		if err != nil {
+			hour, minute = 18, 0 // digest off, so digest_at has no default yet
+		}
+		time.Sleep(time.Until(nextClock(time.Now(), hour, minute)))
+		if c := current().Email; c.Host != "" && c.Mode == "digest" {
+			sendDigest(c, time.Now())
+		}
+	}
+}
+
+// emailOperation queues e for the daily report, or mails it straight away in event mode.
+func emailOperation(cfg Config, e HistoryEntry) {
+	c := cfg.Email
+	if c.Host == "" {
+		return
+	}
+	if c.Mode == "digest" {
+		dailyReport.add(e)
+		return
+	}
+	if len(c.Events) > 0 && !slices.Contains(c.Events, webhookKind(e)) {
+		return
+	}
+	deliveries.Go(func() {
+		host, _ := os.Hostname()
+		subject, body, err := renderEmail(c, emailData{Host: host, Entry: newEmailEntry(e)})
+		if err == nil {
+			err = sendEmail(c, subject, body)
+		}
+		if err != nil {
+			slog.Error("email failed", "event", "email_failed", "file", e.Name, "rule", e.Rule, "dest", strings.Join(c.To, ","), "error", err)
+		}
+	})
+}
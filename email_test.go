@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is what the fake SMTP server received in one session.
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP runs a minimal plaintext SMTP server on localhost that accepts
+// AUTH PLAIN and delivers each message to the returned channel.
+func fakeSMTP(t *testing.T) (host string, port int, msgs <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	ch := make(chan smtpMessage, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return "127.0.0.1", addr.Port, ch
+}
+
+func serveSMTP(conn net.Conn, ch chan<- smtpMessage) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+	var msg smtpMessage
+
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			msg.auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+			reply("235 ok")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.data = data.String()
+			reply("250 queued")
+			ch <- msg
+			msg = smtpMessage{}
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func testEmailConfig(t *testing.T, host string, port int, mode string) EmailConfig {
+	t.Helper()
+	c := EmailConfig{
+		Host:     host,
+		Port:     port,
+		Security: "none",
+		From:     "downwatch@example.com",
+		To:       []string{"me@example.com", "you@example.com"},
+		Mode:     mode,
+	}
+	if err := normalizeEmail(&c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func receive(t *testing.T, msgs <-chan smtpMessage) smtpMessage {
+	t.Helper()
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return smtpMessage{}
+	}
+}
+
+// Test per-event emails are delivered with auth, recipients and the rendered template
+func TestEmailEventMode(t *testing.T) {
+	host, port, msgs := fakeSMTP(t)
+	c := testEmailConfig(t, host, port, "event")
+	c.Username, c.Password = "user", "secret"
+	c.Events = []string{"failure"}
+
+	cfg := Config{Email: c}
+	emailOperation(cfg, HistoryEntry{Time: time.Now(), Name: "ok.pdf", Rule: "Docs", Action: "move", Dest: "/docs/ok.pdf"})
+	emailOperation(cfg, HistoryEntry{Time: time.Now(), Name: "bad.pdf", Rule: "Docs", Action: "move", Error: "disk full"})
+
+	m := receive(t, msgs)
+	if m.auth == "" {
+		t.Error("AUTH PLAIN not sent")
+	}
+	if m.from != c.From {
+		t.Errorf("MAIL FROM = %q, want %q", m.from, c.From)
+	}
+	if len(m.to) != 2 {
+		t.Errorf("RCPT TO = %v, want 2 recipients", m.to)
+	}
+	if !strings.Contains(m.data, "Subject: downwatch on ") || !strings.Contains(m.data, "Failed to move bad.pdf (Docs): disk full") {
+		t.Errorf("unexpected message:\n%s", m.data)
+	}
+	if !strings.Contains(m.data, "Error:  disk full") {
+		t.Errorf("body missing error line:\n%s", m.data)
+	}
+
+	// The success wasn't wanted, so nothing else arrives
+	select {
+	case extra := <-msgs:
+		t.Errorf("unexpected extra email:\n%s", extra.data)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// Test the digest collects operations and sends one report per period
+func TestEmailDigest(t *testing.T) {
+	host, port, msgs := fakeSMTP(t)
+	c := testEmailConfig(t, host, port, "digest")
+	c.Subject = "report: {{len .Entries}} operations"
+	cfg := Config{Email: c}
+
+	dailyReport.take(time.Now()) // reset
+	emailOperation(cfg, HistoryEntry{Time: time.Now(), Name: "a.jpg", Rule: "Pictures", Action: "move", Dest: "/pics/a.jpg"})
+	emailOperation(cfg, HistoryEntry{Time: time.Now(), Name: "b.jpg", Rule: "Pictures", Action: "copy", Dest: "/pics/b.jpg"})
+	emailOperation(cfg, HistoryEntry{Time: time.Now(), Name: "c.zip", Rule: "Archives", Action: "move", Error: "permission denied"})
+
+	sendDigest(c, time.Now())
+	m := receive(t, msgs)
+	for _, want := range []string{"Subject: report: 3 operations", "Failed (1):", "c.zip", "Filed (2):", "-> /pics/a.jpg", "copy  b.jpg"} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("report missing %q:\n%s", want, m.data)
+		}
+	}
+
+	// Nothing new: no report unless send_empty is set
+	sendDigest(c, time.Now())
+	c.SendEmpty = true
+	sendDigest(c, time.Now())
+	m = receive(t, msgs)
+	if !strings.Contains(m.data, "report: 0 operations") || !strings.Contains(m.data, "nothing") {
+		t.Errorf("unexpected empty report:\n%s", m.data)
+	}
+	select {
+	case extra := <-msgs:
+		t.Errorf("unexpected extra email:\n%s", extra.data)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// Test normalizeEmail defaults and validation
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      EmailConfig
+		wantPort int
+		wantErr  bool
+	}{
+		{"disabled", EmailConfig{}, 0, false},
+		{"starttls default", EmailConfig{Host: "smtp.example.com", From: "a@x", To: []string{"b@x"}}, 587, false},
+		{"implicit tls", EmailConfig{Host: "smtp.example.com", Security: "TLS", From: "a@x", To: []string{"b@x"}}, 465, false},
+		{"explicit port", EmailConfig{Host: "smtp.example.com", Port: 2525, From: "a@x", To: []string{"b@x"}}, 2525, false},
+		{"bad security", EmailConfig{Host: "smtp.example.com", Security: "ssl3", From: "a@x", To: []string{"b@x"}}, 0, true},
+		{"no recipients", EmailConfig{Host: "smtp.example.com", From: "a@x"}, 0, true},
+		{"bad mode", EmailConfig{Host: "smtp.example.com", Mode: "weekly", From: "a@x", To: []string{"b@x"}}, 0, true},
+		{"bad digest time", EmailConfig{Host: "smtp.example.com", DigestAt: "25:00", From: "a@x", To: []string{"b@x"}}, 0, true},
+		{"bad event", EmailConfig{Host: "smtp.example.com", Mode: "event", Events: []string{"moved"}, From: "a@x", To: []string{"b@x"}}, 0, true},
+		{"bad template", EmailConfig{Host: "smtp.example.com", Template: "{{.Nope", From: "a@x", To: []string{"b@x"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.cfg
+			err := normalizeEmail(&c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.Port != tt.wantPort {
+				t.Errorf("port = %d, want %d", c.Port, tt.wantPort)
+			}
+		})
+	}
+}
+
+// Test nextClock picks today's time if still ahead, otherwise tomorrow's
+func TestNextClock(t *testing.T) {
+	loc := time.Local
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2025, 3, 1, 9, 0, 0, 0, loc), time.Date(2025, 3, 1, 18, 0, 0, 0, loc)},
+		{time.Date(2025, 3, 1, 18, 0, 0, 0, loc), time.Date(2025, 3, 2, 18, 0, 0, 0, loc)},
+		{time.Date(2025, 3, 31, 23, 30, 0, 0, loc), time.Date(2025, 4, 1, 18, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.now.Day())+"-"+strconv.Itoa(tt.now.Hour()), func(t *testing.T) {
+			if got := nextClock(tt.now, 18, 0); !got.Equal(tt.want) {
+				t.Errorf("nextClock(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
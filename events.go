@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types published on the event stream, in pipeline order
+const (
+	eventDetected    = "detected"
+	eventStabilizing = "stabilizing"
+	eventMatched     = "matched"
+	eventFiled       = "filed"
+	eventUploaded    = "uploaded"
+	eventFailed      = "failed"
+	eventHeld        = "held" // not enough free space; retried later
+)
+
+// FileEvent is one pipeline stage transition for a file.
+type FileEvent struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Path   string    `json:"path"`
+	Name   string    `json:"name"`
+	Rule   string    `json:"rule,omitempty"`
+	Action string    `json:"action,omitempty"` // for filed: move, copy, delete or skip
+	Dest   string    `json:"dest,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Stage  string    `json:"stage,omitempty"` // for failed: stability, mkdir, move, copy, delete or upload
+	Error  string    `json:"error,omitempty"`
+}
+
+// eventBroker fans events out to subscribers and keeps the most recent ones
+// so late subscribers can replay them.
+type eventBroker struct {
+	mu     sync.Mutex
+	lastID uint64
+	ring   []FileEvent
+	next   int
+	full   bool
+	subs   map[chan FileEvent]struct{}
+}
+
+func newEventBroker(size int) *eventBroker {
+	return &eventBroker{ring: make([]FileEvent, size), subs: make(map[chan FileEvent]struct{})}
+}
+
+// Pipeline events for the /events stream
+var events = newEventBroker(500)
+
+// Subscribers that fall this far behind are dropped; they can reconnect with
+// Last-Event-ID and catch up from the ring buffer.
+const subscriberBuffer = 256
+
+func (b *eventBroker) publish(e FileEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Name == "" {
+		e.Name = filepath.Base(e.Path)
+	}
+	b.ring[b.next] = e
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// buffered returns the ring buffer contents, oldest first. Caller holds b.mu.
+func (b *eventBroker) buffered() []FileEvent {
+	if !b.full {
+		return append([]FileEvent(nil), b.ring[:b.next]...)
+	}
+	out := append([]FileEvent(nil), b.ring[b.next:]...)
+	return append(out, b.ring[:b.next]...)
+}
+
+// subscribe registers a new subscriber. The backlog holds buffered events with
+// an ID greater than afterID, limited to the newest replay events when replay >= 0.
+// The channel is closed if the subscriber falls too far behind.
+func (b *eventBroker) subscribe(afterID uint64, replay int) (backlog []FileEvent, ch chan FileEvent, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, e := range b.buffered() {
+		if e.ID > afterID {
+			backlog = append(backlog, e)
+		}
+	}
+	if replay >= 0 && len(backlog) > replay {
+		backlog = backlog[len(backlog)-replay:]
+	}
+
+	ch = make(chan FileEvent, subscriberBuffer)
+	b.subs[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// eventFromEntry converts a recorded operation into a filed or failed event.
+func eventFromEntry(e HistoryEntry) FileEvent {
+	ev := FileEvent{
+		Time:   e.Time,
+		Type:   eventFiled,
+		Path:   e.Source,
+		Name:   e.Name,
+		Rule:   e.Rule,
+		Action: e.Action,
+		Dest:   e.Dest,
+		Bytes:  e.Size,
+	}
+	if e.Error != "" {
+		ev.Type = eventFailed
+		ev.Stage = e.Action
+		ev.Error = e.Error
+	}
+	return ev
+}
+
+func writeSSE(w http.ResponseWriter, e FileEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// sseKeepalive is how often an idle stream gets a comment line so proxies keep it open.
+const sseKeepalive = 30 * time.Second
+
+// serveEvents streams pipeline events as Server-Sent Events. A reconnecting
+// client's Last-Event-ID header replays everything it missed that is still
+// buffered; ?replay=N sends the N most recent events to a new subscriber.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var afterID uint64
+	replay := 0
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		afterID, replay = id, -1
+	} else if s := r.URL.Query().Get("replay"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "replay must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		replay = n
+	}
+
+	backlog, ch, cancel := events.subscribe(afterID, replay)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range backlog {
+		if writeSSE(w, e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, open := <-ch:
+			if !open {
+				return // too slow; client reconnects with Last-Event-ID
+			}
+			if writeSSE(w, e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
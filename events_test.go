@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the ring buffer replays recent events to late subscribers
+func TestEventBrokerReplay(t *testing.T) {
+	b := newEventBroker(3)
+	for _, p := range []string{"/dl/1", "/dl/2", "/dl/3", "/dl/4"} {
+		b.publish(FileEvent{Type: eventDetected, Path: p})
+	}
+
+	backlog, _, cancel := b.subscribe(0, -1)
+	cancel()
+	if len(backlog) != 3 || backlog[0].Path != "/dl/2" || backlog[2].Path != "/dl/4" {
+		t.Errorf("full replay = %v, want /dl/2../dl/4", backlog)
+	}
+	if backlog[0].Name != "2" {
+		t.Errorf("Name = %q, want base name filled in", backlog[0].Name)
+	}
+
+	backlog, _, cancel = b.subscribe(3, -1)
+	cancel()
+	if len(backlog) != 1 || backlog[0].ID != 4 {
+		t.Errorf("replay after ID 3 = %v, want only ID 4", backlog)
+	}
+
+	backlog, _, cancel = b.subscribe(0, 2)
+	cancel()
+	if len(backlog) != 2 || backlog[0].ID != 3 {
+		t.Errorf("replay=2 = %v, want IDs 3,4", backlog)
+	}
+}
+
+// Test slow subscribers are dropped instead of blocking publishers
+func TestEventBrokerDropsSlowSubscriber(t *testing.T) {
+	b := newEventBroker(10)
+	_, ch, cancel := b.subscribe(0, 0)
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.publish(FileEvent{Type: eventDetected, Path: "/dl/x"})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before close, want %d", n, subscriberBuffer)
+	}
+}
+
+// Test eventFromEntry maps outcomes to filed/failed
+func TestEventFromEntry(t *testing.T) {
+	ok := eventFromEntry(HistoryEntry{Name: "a.pdf", Rule: "PDFs", Action: "move", Source: "/dl/a.pdf", Dest: "/docs/a.pdf"})
+	if ok.Type != eventFiled || ok.Path != "/dl/a.pdf" || ok.Dest != "/docs/a.pdf" {
+		t.Errorf("success event = %+v", ok)
+	}
+	bad := eventFromEntry(HistoryEntry{Name: "a.pdf", Action: "copy", Error: "disk full"})
+	if bad.Type != eventFailed || bad.Stage != "copy" || bad.Error != "disk full" {
+		t.Errorf("failure event = %+v", bad)
+	}
+}
+
+// Test the SSE endpoint replays and then streams live events
+func TestServeEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(serveEvents))
+	defer srv.Close()
+
+	events.publish(FileEvent{Type: eventMatched, Path: "/dl/replayed.pdf", Rule: "PDFs"})
+
+	resp, err := http.Get(srv.URL + "?replay=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	deadline := time.AfterFunc(5*time.Second, func() { _ = resp.Body.Close() })
+	defer deadline.Stop()
+	var data []string
+	for sc.Scan() && len(data) < 2 {
+		if d, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			data = append(data, d)
+			if len(data) == 1 {
+				// Subscribed and replayed; anything published now arrives live
+				events.publish(FileEvent{Type: eventFiled, Path: "/dl/live.pdf"})
+			}
+		}
+	}
+	if len(data) != 2 {
+		t.Fatalf("got %d data lines, want 2", len(data))
+	}
+	if !strings.Contains(data[0], `"type":"matched"`) || !strings.Contains(data[0], "replayed.pdf") {
+		t.Errorf("first event = %s, want replayed matched event", data[0])
+	}
+	if !strings.Contains(data[1], `"type":"filed"`) || !strings.Contains(data[1], "live.pdf") {
+		t.Errorf("second event = %s, want live filed event", data[1])
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// exifData is the photo metadata downwatch uses, from JPEG, TIFF or HEIC.
+type exifData struct {
+	Make   string
+	Model  string
+	Taken  time.Time // DateTimeOriginal, else DateTime; zero if neither
+	HasGPS bool
+}
+
+var errNoEXIF = errors.New("no EXIF data")
+
+// TIFF tags
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagGPSLatitude      = 0x0002
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// readEXIF extracts EXIF metadata from the JPEG, TIFF or HEIC file at path.
+// It returns errNoEXIF for other files and images without EXIF.
+func readEXIF(path string) (*exifData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	head := make([]byte, 12)
+	if _, errRead := io.ReadFull(f, head); errRead != nil {
+		return nil, errNoEXIF
+	}
+	var tiff *io.SectionReader
+	switch {
+	case head[0] == 0xff && head[1] == 0xd8:
+		tiff, err = jpegEXIF(f, size)
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		tiff = io.NewSectionReader(f, 0, size)
+	case string(head[4:8]) == "ftyp":
+		tiff, err = heifEXIF(f, size)
+	default:
+		return nil, errNoEXIF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTIFF(tiff)
+}
+
+// jpegEXIF finds the APP1 "Exif" segment and returns the TIFF data in it.
+func jpegEXIF(r io.ReaderAt, size int64) (*io.SectionReader, error) {
+	off := int64(2)
+	hdr := make([]byte, 4)
+	for off+4 <= size {
+		if _, err := r.ReadAt(hdr, off); err != nil {
+			return nil, errNoEXIF
+		}
+		if hdr[0] != 0xff {
+			return nil, errNoEXIF
+		}
+		marker := hdr[1]
+		switch {
+		case marker == 0xff:
+			off++ // fill byte
+			continue
+		case marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			off += 2 // no length
+			continue
+		case marker == 0xda || marker == 0xd9:
+			return nil, errNoEXIF // image data: metadata comes before it
+		}
+		segLen := int64(binary.BigEndian.Uint16(hdr[2:]))
+		if segLen < 2 {
+			return nil, errNoEXIF
+		}
+		if marker == 0xe1 && segLen >= 8 {
+			id := make([]byte, 6)
+			if _, err := r.ReadAt(id, off+4); err == nil && string(id) == "Exif\x00\x00" {
+				return io.NewSectionReader(r, off+10, segLen-8), nil
+			}
+		}
+		off += 2 + segLen
+	}
+	return nil, errNoEXIF
+}
+
+// heifEXIF finds the Exif item of a HEIC/HEIF file through its meta box
+// (iinf names the item, iloc says where it is) and returns the TIFF data.
+func heifEXIF(r io.ReaderAt, size int64) (*io.SectionReader, error) {
+	meta, ok := findBox(io.NewSectionReader(r, 0, size), "meta")
+	if !ok || meta.Size() < 4 || meta.Size() > 4<<20 {
+		return nil, errNoEXIF
+	}
+	b := make([]byte, meta.Size())
+	if _, err := meta.ReadAt(b, 0); err != nil {
+		return nil, errNoEXIF
+	}
+	children := b[4:] // after version and flags
+	iinf, okInf := boxIn(children, "iinf")
+	iloc, okLoc := boxIn(children, "iloc")
+	if !okInf || !okLoc {
+		return nil, errNoEXIF
+	}
+	id, ok := exifItemID(iinf)
+	if !ok {
+		return nil, errNoEXIF
+	}
+	start, length, ok := itemLocation(iloc, id)
+	if !ok || length < 4 || start < 0 || start+length > size {
+		return nil, errNoEXIF
+	}
+	// The item starts with the offset of the TIFF header after these 4 bytes
+	var skip [4]byte
+	if _, err := r.ReadAt(skip[:], start); err != nil {
+		return nil, errNoEXIF
+	}
+	tiffOff := 4 + int64(binary.BigEndian.Uint32(skip[:]))
+	if tiffOff >= length {
+		return nil, errNoEXIF
+	}
+	return io.NewSectionReader(r, start+tiffOff, length-tiffOff), nil
+}
+
+// findBox returns the payload of the first top-level ISO BMFF box of type
+// typ in r, skipping over others (such as a large mdat) without reading them.
+func findBox(r *io.SectionReader, typ string) (*io.SectionReader, bool) {
+	var off int64
+	hdr := make([]byte, 16)
+	for off+8 <= r.Size() {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, false
+		}
+		boxSize, hdrLen := int64(binary.BigEndian.Uint32(hdr)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = r.Size() - off
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, false
+			}
+			boxSize, hdrLen = int64(binary.BigEndian.Uint64(hdr[8:16])), 16
+		}
+		if boxSize < hdrLen || off+boxSize > r.Size() {
+			return nil, false
+		}
+		if string(hdr[4:8]) == typ {
+			return io.NewSectionReader(r, off+hdrLen, boxSize-hdrLen), true
+		}
+		off += boxSize
+	}
+	return nil, false
+}
+
+// boxIn returns the payload of the first box of type typ in b.
+func boxIn(b []byte, typ string) ([]byte, bool) {
+	for len(b) >= 8 {
+		n := int(binary.BigEndian.Uint32(b))
+		if n == 0 {
+			n = len(b)
+		}
+		if n < 8 || n > len(b) {
+			return nil, false
+		}
+		if string(b[4:8]) == typ {
+			return b[8:n], true
+		}
+		b = b[n:]
+	}
+	return nil, false
+}
+
+// exifItemID returns the ID of the item of type "Exif" in an iinf payload.
+func exifItemID(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:] // version, flags, 16-bit entry count
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:] // 32-bit entry count
+	}
+	for len(entries) >= 8 {
+		n := int(binary.BigEndian.Uint32(entries))
+		if n < 8 || n > len(entries) {
+			return 0, false
+		}
+		if e := entries[8:n]; string(entries[4:8]) == "infe" {
+			// Version 2 has a 16-bit item ID, version 3 a 32-bit one
+			switch {
+			case len(e) >= 12 && e[0] == 2 && string(e[8:12]) == "Exif":
+				return uint32(binary.BigEndian.Uint16(e[4:6])), true
+			case len(e) >= 14 && e[0] == 3 && string(e[10:14]) == "Exif":
+				return binary.BigEndian.Uint32(e[4:8]), true
+			}
+		}
+		entries = entries[n:]
+	}
+	return 0, false
+}
+
+// itemLocation returns the file offset and length of item id's first
+// extent from an iloc payload. Only items stored in the file itself
+// (construction method 0) are supported.
+func itemLocation(iloc []byte, id uint32) (start, length int64, ok bool) {
+	rd := &byteReader{b: iloc}
+	version := rd.uint(1)
+	rd.uint(3) // flags
+	sizes := rd.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = rd.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := rd.uint(idSize)
+	for i := uint64(0); i < count && !rd.short; i++ {
+		itemID := rd.uint(idSize)
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = rd.uint(2) & 0xf
+		}
+		rd.uint(2) // data reference index
+		base := rd.uint(baseOffsetSize)
+		extents := rd.uint(2)
+		for e := uint64(0); e < extents && !rd.short; e++ {
+			rd.uint(indexSize)
+			off, n := rd.uint(offsetSize), rd.uint(lengthSize)
+			if e == 0 && uint32(itemID) == id && !rd.short {
+				return int64(base + off), int64(n), method == 0
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// byteReader reads big-endian integers of 0 to 8 bytes, remembering
+// whether it ran out of input.
+type byteReader struct {
+	b     []byte
+	short bool
+}
+
+func (r *byteReader) uint(n int) uint64 {
+	if n > len(r.b) || n > 8 {
+		r.short = true
+		r.b = nil
+		return 0
+	}
+	var v uint64
+	for _, c := range r.b[:n] {
+		v = v<<8 | uint64(c)
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// parseTIFF reads the tags downwatch uses from TIFF-structured EXIF data.
+func parseTIFF(r *io.SectionReader) (*exifData, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, errNoEXIF
+	}
+	var order binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errNoEXIF
+	}
+	if order.Uint16(hdr[2:]) != 42 {
+		return nil, errNoEXIF
+	}
+	t := tiffReader{r: r, order: order}
+	ifd0 := t.ifd(int64(order.Uint32(hdr[4:])))
+	if ifd0 == nil {
+		return nil, errNoEXIF
+	}
+
+	x := &exifData{
+		Make:  t.ascii(ifd0[tagMake]),
+		Model: t.ascii(ifd0[tagModel]),
+	}
+	taken := t.ascii(ifd0[tagDateTime])
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub := t.ifd(int64(t.long(e))); sub != nil {
+			if v := t.ascii(sub[tagDateTimeOriginal]); v != "" {
+				taken = v
+			}
+		}
+	}
+	if g, ok := ifd0[tagGPSIFD]; ok {
+		if gps := t.ifd(int64(t.long(g))); gps != nil {
+			_, x.HasGPS = gps[tagGPSLatitude]
+		}
+	}
+	if tm, err := time.ParseInLocation(exifTimeLayout, taken, time.Local); err == nil {
+		x.Taken = tm
+	}
+	return x, nil
+}
+
+// tiffEntry is a raw 12-byte IFD entry: type, count and the value or its offset.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+type tiffReader struct {
+	r     *io.SectionReader
+	order binary.ByteOrder
+}
+
+// ifd reads the IFD at off, or returns nil if it is out of range.
+func (t tiffReader) ifd(off int64) map[uint16]tiffEntry {
+	var n [2]byte
+	if off <= 0 || off+2 > t.r.Size() {
+		return nil
+	}
+	if _, err := t.r.ReadAt(n[:], off); err != nil {
+		return nil
+	}
+	count := int64(t.order.Uint16(n[:]))
+	buf := make([]byte, count*12)
+	if _, err := t.r.ReadAt(buf, off+2); err != nil {
+		return nil
+	}
+	entries := make(map[uint16]tiffEntry, count)
+	for i := int64(0); i < count; i++ {
+		e := buf[i*12 : i*12+12]
+		var te tiffEntry
+		te.typ = t.order.Uint16(e[2:])
+		te.count = t.order.Uint32(e[4:])
+		copy(te.value[:], e[8:12])
+		entries[t.order.Uint16(e)] = te
+	}
+	return entries
+}
+
+// long returns a LONG (or SHORT) entry's value.
+func (t tiffReader) long(e tiffEntry) uint32 {
+	if e.typ == 3 {
+		return uint32(t.order.Uint16(e.value[:]))
+	}
+	return t.order.Uint32(e.value[:])
+}
+
+// ascii returns an ASCII entry's string, trimmed of NULs and spaces.
+func (t tiffReader) ascii(e tiffEntry) string {
+	if e.typ != 2 || e.count == 0 || e.count > 1024 {
+		return ""
+	}
+	b := make([]byte, e.count)
+	if e.count <= 4 {
+		copy(b, e.value[:e.count])
+	} else if _, err := t.r.ReadAt(b, int64(t.order.Uint32(e.value[:]))); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// hasEXIFMatchers reports whether r matches on photo metadata.
+func (r *Rule) hasEXIFMatchers() bool {
+	return len(r.CameraMakes) > 0 || len(r.CameraModels) > 0 || r.HasGPS != nil
+}
+
+// exifMatches reports whether x matches all of r's EXIF matchers, so
+// camera_models: ["Pixel 8"] with has_gps: true takes only Pixel 8 photos
+// with a position. Files without EXIF match none of them, not even
+// has_gps: false.
+func (r *Rule) exifMatches(x *exifData) bool {
+	if x == nil {
+		return false
+	}
+	if len(r.CameraMakes) > 0 && !foldMatches(x.Make, r.CameraMakes) {
+		return false
+	}
+	if len(r.CameraModels) > 0 && !foldMatches(x.Model, r.CameraModels) {
+		return false
+	}
+	return r.HasGPS == nil || *r.HasGPS == x.HasGPS
+}
+
+// foldMatches matches v against globs case-insensitively; an empty v never matches.
+func foldMatches(v string, patterns []string) bool {
+	if v == "" {
+		return false
+	}
+	v = strings.ToLower(v)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// takenTime is when the photo was taken, or the file's mtime without EXIF.
+func (m *fileMeta) takenTime() time.Time {
+	if x := m.exif(); x != nil && !x.Taken.IsZero() {
+		return x.Taken
+	}
+	if fi, err := os.Stat(m.path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tiffEXIF builds little-endian TIFF data with Make, Model, an Exif IFD
+// holding DateTimeOriginal and, if gps is set, a GPS IFD with a latitude.
+func tiffEXIF(camMake, model, taken string, gps bool) []byte {
+	le := binary.LittleEndian
+	n0 := 3
+	if gps {
+		n0 = 4
+	}
+	exifOff := 8 + 2 + 12*n0 + 4
+	gpsOff := exifOff + 18
+	dataOff := gpsOff + 18
+
+	var data []byte
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		e := make([]byte, 12)
+		le.PutUint16(e, tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint32(e[4:], count)
+		le.PutUint32(e[8:], value)
+		return e
+	}
+	ascii := func(tag uint16, s string) []byte {
+		off := dataOff + len(data)
+		data = append(data, s+"\x00"...)
+		return entry(tag, 2, uint32(len(s)+1), uint32(off))
+	}
+	ifd := func(entries ...[]byte) []byte {
+		b := le.AppendUint16(nil, uint16(len(entries)))
+		for _, e := range entries {
+			b = append(b, e...)
+		}
+		return le.AppendUint32(b, 0) // no next IFD
+	}
+
+	b := []byte("II*\x00")
+	b = le.AppendUint32(b, 8)
+	ifd0 := [][]byte{ascii(tagMake, camMake), ascii(tagModel, model), entry(tagExifIFD, 4, 1, uint32(exifOff))}
+	if gps {
+		ifd0 = append(ifd0, entry(tagGPSIFD, 4, 1, uint32(gpsOff)))
+	}
+	b = append(b, ifd(ifd0...)...)
+	b = append(b, ifd(ascii(tagDateTimeOriginal, taken))...)
+	b = append(b, ifd(entry(tagGPSLatitude, 5, 3, 0))...)
+	return append(b, data...)
+}
+
+// jpegWithEXIF wraps tiff in an APP1 segment after a JFIF APP0 segment.
+func jpegWithEXIF(tiff []byte) []byte {
+	b := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}
+	b = append(b, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"...)
+	b = append(b, 0xff, 0xe1)
+	b = binary.BigEndian.AppendUint16(b, uint16(2+6+len(tiff)))
+	b = append(b, "Exif\x00\x00"...)
+	b = append(b, tiff...)
+	return append(b, 0xff, 0xda, 0x00, 0x02, 0xff, 0xd9)
+}
+
+// heicWithEXIF builds a HEIC file whose meta box points at an Exif item in mdat.
+func heicWithEXIF(tiff []byte) []byte {
+	be := binary.BigEndian
+	box := func(typ string, payload ...[]byte) []byte {
+		n := 8
+		for _, p := range payload {
+			n += len(p)
+		}
+		b := be.AppendUint32(nil, uint32(n))
+		b = append(b, typ...)
+		for _, p := range payload {
+			b = append(b, p...)
+		}
+		return b
+	}
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	// infe version 2: item 1 of type Exif, empty name
+	infe := box("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	item := append([]byte{0, 0, 0, 0}, tiff...) // TIFF header offset, then TIFF
+
+	ilocFor := func(start int) []byte {
+		b := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+		b = be.AppendUint32(b, uint32(start))
+		return be.AppendUint32(b, uint32(len(item)))
+	}
+	meta := func(start int) []byte {
+		return box("meta", []byte{0, 0, 0, 0}, iinf, box("iloc", ilocFor(start)))
+	}
+	start := len(ftyp) + len(meta(0)) + 8
+	b := append(ftyp, meta(start)...)
+	return append(b, box("mdat", item)...)
+}
+
+// Test readEXIF on JPEG, TIFF and HEIC, and errNoEXIF for other files
+func TestReadEXIF(t *testing.T) {
+	tiff := tiffEXIF("Google", "Pixel 8", "2024:03:15 10:20:30", true)
+	want := time.Date(2024, 3, 15, 10, 20, 30, 0, time.Local)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{"photo.jpg", jpegWithEXIF(tiff), false},
+		{"photo.tif", tiff, false},
+		{"photo.heic", heicWithEXIF(tiff), false},
+		{"plain.jpg", []byte{0xff, 0xd8, 0xff, 0xda, 0x00, 0x02, 0xff, 0xd9}, true},
+		{"doc.pdf", []byte("%PDF-1.7\n%%EOF\n"), true},
+		{"tiny.jpg", []byte{0xff, 0xd8}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name)
+			writeFile(t, p, string(tt.content))
+			x, err := readEXIF(p)
+			if tt.wantErr {
+				if !errors.Is(err, errNoEXIF) {
+					t.Errorf("readEXIF() error = %v, want errNoEXIF", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readEXIF() error = %v", err)
+			}
+			if x.Make != "Google" || x.Model != "Pixel 8" || !x.Taken.Equal(want) || !x.HasGPS {
+				t.Errorf("readEXIF() = %+v", x)
+			}
+		})
+	}
+}
+
+// Test exifMatches on camera globs and GPS, all of which must match, and that
+// no EXIF matches nothing
+func TestEXIFMatches(t *testing.T) {
+	yes, no := true, false
+	pixel := &exifData{Make: "Google", Model: "Pixel 8", HasGPS: true}
+	tests := []struct {
+		name string
+		rule Rule
+		x    *exifData
+		want bool
+	}{
+		{"model", Rule{CameraModels: []string{"Pixel 8"}}, pixel, true},
+		{"model case", Rule{CameraModels: []string{"pixel 8"}}, pixel, true},
+		{"model glob", Rule{CameraModels: []string{"Pixel *"}}, pixel, true},
+		{"other model", Rule{CameraModels: []string{"iPhone 1*"}}, pixel, false},
+		{"make", Rule{CameraMakes: []string{"google"}}, pixel, true},
+		{"gps", Rule{HasGPS: &yes}, pixel, true},
+		{"no gps", Rule{HasGPS: &no}, pixel, false},
+		{"empty model", Rule{CameraModels: []string{"*"}}, &exifData{}, false},
+		{"no exif", Rule{HasGPS: &no}, nil, false},
+		{"make and gps", Rule{CameraMakes: []string{"google"}, HasGPS: &yes}, pixel, true},
+		{"gps but other make", Rule{CameraMakes: []string{"Canon"}, HasGPS: &yes}, pixel, false},
+		{"model but no gps wanted", Rule{CameraModels: []string{"Pixel 8"}, HasGPS: &no}, pixel, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.exifMatches(tt.x); got != tt.want {
+				t.Errorf("exifMatches(%+v) = %v, want %v", tt.x, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test chooseRule picks a rule by camera model ahead of a later extension rule
+func TestChooseRuleByCamera(t *testing.T) {
+	dir := t.TempDir()
+	pixel := filepath.Join(dir, "pixel.jpg")
+	other := filepath.Join(dir, "other.jpg")
+	writeFile(t, pixel, string(jpegWithEXIF(tiffEXIF("Google", "Pixel 8", "2024:03:15 10:20:30", false))))
+	writeFile(t, other, string(jpegWithEXIF(tiffEXIF("Canon", "EOS R6", "2024:03:15 10:20:30", false))))
+	rules := []Rule{
+		{Name: "Pixel", CameraModels: []string{"Pixel*"}},
+		{Name: "Photos", Extensions: []string{"jpg"}},
+	}
+	for path, want := range map[string]string{pixel: "Pixel", other: "Photos"} {
+		if r := chooseRule(path, rules); r == nil || r.Name != want {
+			t.Errorf("chooseRule(%s) = %v, want %s", filepath.Base(path), r, want)
+		}
+	}
+}
+
+// Test a rule with extensions and camera_models only takes that camera's photos
+func TestChooseRuleCameraAndExtension(t *testing.T) {
+	dir := t.TempDir()
+	pixel := filepath.Join(dir, "pixel.jpg")
+	other := filepath.Join(dir, "other.jpg")
+	writeFile(t, pixel, string(jpegWithEXIF(tiffEXIF("Google", "Pixel 8", "2024:03:15 10:20:30", false))))
+	writeFile(t, other, string(jpegWithEXIF(tiffEXIF("Canon", "EOS R6", "2024:03:15 10:20:30", false))))
+	rules := []Rule{{Name: "Pixel", Extensions: []string{"jpg"}, CameraModels: []string{"Pixel 8"}}}
+	if r := chooseRule(pixel, rules); r == nil {
+		t.Error("Pixel 8 photo not matched")
+	}
+	if r := chooseRule(other, rules); r != nil {
+		t.Errorf("other camera's jpg matched %s", r.Name)
+	}
+}
+
+// Test exif placeholders use the EXIF date, and the mtime without EXIF
+func TestExpandDestEXIF(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	writeFile(t, photo, string(jpegWithEXIF(tiffEXIF("Google", "Pixel 8", "2023:11:05 08:00:00", false))))
+	plain := filepath.Join(dir, "scan.png")
+	writeFile(t, plain, "\x89PNG\r\n\x1a\n")
+	mtime := time.Date(2021, 7, 9, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(plain, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dest, path, want string
+	}{
+		{"/pics/{exif.year}/{exif.month}", photo, "/pics/2023/11"},
+		{"/pics/{exif.make}/{exif.model}/{exif.day}", photo, "/pics/Google/Pixel 8/05"},
+		{"/pics/{exif.year}/{exif.month}/{exif.day}", plain, "/pics/2021/07/09"},
+		{"/pics/{exif.model}", plain, "/pics/unknown"},
+	}
+	for _, tt := range tests {
+		if got := expandDest(tt.dest, tt.path); got != tt.want {
+			t.Errorf("expandDest(%q, %s) = %q, want %q", tt.dest, filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
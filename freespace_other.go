@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// freeSpace isn't implemented here; callers assume there is room.
+func freeSpace(string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func sameFilesystem(string, string) bool { return false }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the bytes available to the current user on the volume holding dir.
+func freeSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, totalFree uint64
+	if errSpace := windows.GetDiskFreeSpaceEx(p, &avail, &total, &totalFree); errSpace != nil {
+		return 0, errSpace
+	}
+	return avail, nil
+}
+
+// sameFilesystem reports whether a and b are on the same volume.
+func sameFilesystem(a, b string) bool {
+	va, vb := filepath.VolumeName(a), filepath.VolumeName(b)
+	return va != "" && strings.EqualFold(va, vb)
+}
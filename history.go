@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryEntry is one line of the history database: a file downwatch acted on.
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`            // base filename at the time it was processed
+	Rule   string    `json:"rule,omitempty"`  // rule that matched
+	Action string    `json:"action"`          // "move", "copy", "delete" (duplicate source removed), "skip" (duplicate not copied), or for failures "upload" (WebDAV) and "stability" (never settled)
+	Source string    `json:"source"`          // original location
+	Dest   string    `json:"dest,omitempty"`  // final location (destination directory for delete/skip)
+	Size   int64     `json:"size"`            // bytes
+	Error  string    `json:"error,omitempty"` // set when the action failed
+}
+
+// summary describes the operation in one line for notifications and webhooks.
+func (e HistoryEntry) summary() string {
+	if e.Action == "stability" {
+		return fmt.Sprintf("Gave up waiting for %s to finish (%s): %s", e.Name, e.Rule, e.Error)
+	}
+	if e.Error != "" {
+		return fmt.Sprintf("Failed to %s %s (%s): %s", e.Action, e.Name, e.Rule, e.Error)
+	}
+	switch e.Action {
+	case "move":
+		return fmt.Sprintf("Moved %s to %s (%s)", e.Name, filepath.Dir(e.Dest), e.Rule)
+	case "copy":
+		return fmt.Sprintf("Copied %s to %s (%s)", e.Name, filepath.Dir(e.Dest), e.Rule)
+	case "delete":
+		return fmt.Sprintf("Deleted %s, already in %s (%s)", e.Name, e.Dest, e.Rule)
+	case "skip":
+		return fmt.Sprintf("Skipped %s, already in %s (%s)", e.Name, e.Dest, e.Rule)
+	}
+	return fmt.Sprintf("%s %s (%s)", e.Action, e.Name, e.Rule)
+}
+
+// Serialize appends so concurrent handleFile goroutines don't interleave lines
+var historyMu sync.Mutex
+
+// appendHistory adds e to the JSON-lines history file at path, creating it if needed.
+func appendHistory(path string, e HistoryEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if errDir := ensureDir(filepath.Dir(path)); errDir != nil {
+		return errDir
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, errWrite := f.Write(line); errWrite != nil {
+		_ = f.Close()
+		return errWrite
+	}
+	return f.Close()
+}
+
+// readHistory loads all entries from the history file in the order they were written.
+// Lines that fail to parse (e.g. a write cut short by a crash) are skipped.
+func readHistory(path string) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return decodeHistory(f)
+}
+
+func decodeHistory(r io.Reader) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// Background notification deliveries; a one-shot run waits for them before exiting
+var deliveries sync.WaitGroup
+
+// recordOperation stores the outcome of handling a file in the metrics, the
+// status API's recent operations, the event stream, and the history, and
+// sends it to the desktop notifier, configured webhooks and email. Failures
+// to write history are logged but never interrupt file processing.
+func recordOperation(cfg Config, e HistoryEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	observeOperation(e)
+	ops.add(e)
+	events.publish(eventFromEntry(e))
+	notifyOperation(cfg, e)
+	sendWebhooks(cfg, e)
+	emailOperation(cfg, e)
+	if cfg.HistoryFile == "" {
+		return
+	}
+	if err := appendHistory(cfg.HistoryFile, e); err != nil {
+		slog.Warn("history write failed", "event", "history_failed", "file", e.Name, "error", err)
+	}
+}
+
+type historyFilter struct {
+	Name       string // filepath.Match glob against the filename, case-insensitive
+	Rule       string
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	FailedOnly bool
+}
+
+func (f historyFilter) match(e HistoryEntry) bool {
+	if f.Name != "" {
+		if ok, _ := filepath.Match(strings.ToLower(f.Name), strings.ToLower(e.Name)); !ok {
+			return false
+		}
+	}
+	if f.Rule != "" && !strings.EqualFold(f.Rule, e.Rule) {
+		return false
+	}
+	if f.Action != "" && !strings.EqualFold(f.Action, e.Action) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.FailedOnly && e.Error == "" {
+		return false
+	}
+	return true
+}
+
+// parseHistoryTime accepts a date ("2006-01-02"), a local timestamp
+// ("2006-01-02 15:04"), RFC 3339, or a relative age such as "36h" or "7d".
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q (want YYYY-MM-DD, RFC 3339, or an age like 7d)", s)
+}
+
+func printHistoryTable(w io.Writer, entries []HistoryEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TIME\tACTION\tRULE\tNAME\tFROM\tTO")
+	for _, e := range entries {
+		action := e.Action
+		if e.Error != "" {
+			action += " (failed)"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), action, e.Rule, e.Name, e.Source, e.Dest)
+	}
+	return tw.Flush()
+}
+
+// runHistory implements the `downwatch history` subcommand and returns the exit code.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	cfgPath := fs.String("config", "", "config file to read history_file from")
+	file := fs.String("file", "", "history file to read (overrides -config)")
+	name := fs.String("name", "", "only files whose name matches this glob (case-insensitive)")
+	rule := fs.String("rule", "", "only files handled by this rule")
+	action := fs.String("action", "", "only this action: move, copy, delete or skip")
+	since := fs.String("since", "", "only entries at or after this time (YYYY-MM-DD, RFC 3339, or age like 7d)")
+	until := fs.String("until", "", "only entries before this time")
+	failed := fs.Bool("failed", false, "only failed operations")
+	asJSON := fs.Bool("json", false, "print entries as a JSON array")
+	limit := fs.Int("limit", 0, "show at most this many of the most recent matches (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	path := *file
+	if path == "" {
+		cfg := defaultConfig()
+		if *cfgPath != "" {
+			var err error
+			if cfg, err = loadConfig(*cfgPath); err != nil {
+				fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+				return 1
+			}
+		}
+		path = cfg.HistoryFile
+	}
+	path, err := expandHome(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history file: %v\n", err)
+		return 1
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "history is disabled (history_file is empty)")
+		return 1
+	}
+
+	now := time.Now()
+	filter := historyFilter{Name: *name, Rule: *rule, Action: *action, FailedOnly: *failed}
+	if filter.Since, err = parseHistoryTime(*since, now); err != nil {
+		fmt.Fprintf(os.Stderr, "-since: %v\n", err)
+		return 2
+	}
+	if filter.Until, err = parseHistoryTime(*until, now); err != nil {
+		fmt.Fprintf(os.Stderr, "-until: %v\n", err)
+		return 2
+	}
+
+	entries, err := readHistory(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "reading history: %v\n", err)
+		return 1
+	}
+	matched := make([]HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if *limit > 0 && len(matched) > *limit {
+		matched = matched[len(matched)-*limit:]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(matched)
+	} else {
+		err = printHistoryTable(os.Stdout, matched)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "output: %v\n", err)
+		return 1
+	}
+	return 0
+}
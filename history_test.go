@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test appendHistory/readHistory round trip
+func TestHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	when := time.Date(2025, 3, 14, 9, 26, 0, 0, time.UTC)
+
+	entries := []HistoryEntry{
+		{Time: when, Name: "report.pdf", Rule: "PDFs", Action: "move", Source: "/dl/report.pdf", Dest: "/docs/report.pdf", Size: 42},
+		{Time: when.Add(time.Hour), Name: "clip.mp4", Rule: "Videos", Action: "copy", Source: "/dl/clip.mp4", Error: "disk full"},
+	}
+	for _, e := range entries {
+		if err := appendHistory(path, e); err != nil {
+			t.Fatalf("appendHistory: %v", err)
+		}
+	}
+
+	got, err := readHistory(path)
+	if err != nil {
+		t.Fatalf("readHistory: %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("readHistory returned %d entries, want %d", len(got), len(entries))
+	}
+	for i := range entries {
+		if !got[i].Time.Equal(entries[i].Time) || got[i].Name != entries[i].Name || got[i].Dest != entries[i].Dest || got[i].Error != entries[i].Error {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], entries[i])
+		}
+	}
+}
+
+// Test a truncated trailing line is skipped rather than failing the read
+func TestDecodeHistorySkipsBrokenLines(t *testing.T) {
+	in := `{"time":"2025-01-01T00:00:00Z","name":"a.pdf","action":"move","source":"/dl/a.pdf","size":1}
+{"time":"2025-01-02T00:0`
+	got, err := decodeHistory(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("decodeHistory: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "a.pdf" {
+		t.Errorf("decodeHistory = %+v, want only a.pdf", got)
+	}
+}
+
+// Test recordOperation honours an empty history_file
+func TestRecordOperationDisabled(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.HistoryFile = ""
+	recordOperation(cfg, HistoryEntry{Name: "x", Action: "move"})
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("history written with history_file disabled: %v", entries)
+	}
+}
+
+// Test historyFilter matching
+func TestHistoryFilter(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	e := HistoryEntry{Time: base, Name: "Invoice-2025.PDF", Rule: "PDFs", Action: "move"}
+	failed := e
+	failed.Error = "permission denied"
+
+	tests := []struct {
+		name   string
+		filter historyFilter
+		entry  HistoryEntry
+		want   bool
+	}{
+		{"empty filter", historyFilter{}, e, true},
+		{"name glob case-insensitive", historyFilter{Name: "invoice-*.pdf"}, e, true},
+		{"name glob mismatch", historyFilter{Name: "*.zip"}, e, false},
+		{"rule", historyFilter{Rule: "pdfs"}, e, true},
+		{"other rule", historyFilter{Rule: "Images"}, e, false},
+		{"action", historyFilter{Action: "move"}, e, true},
+		{"other action", historyFilter{Action: "copy"}, e, false},
+		{"since inclusive", historyFilter{Since: base}, e, true},
+		{"since after", historyFilter{Since: base.Add(time.Second)}, e, false},
+		{"until exclusive", historyFilter{Until: base}, e, false},
+		{"until after", historyFilter{Until: base.Add(time.Second)}, e, true},
+		{"failed only, success", historyFilter{FailedOnly: true}, e, false},
+		{"failed only, failure", historyFilter{FailedOnly: true}, failed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.entry); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test parseHistoryTime formats
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2025, 6, 10, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2025-06-01", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2025-06-01 08:30", time.Date(2025, 6, 1, 8, 30, 0, 0, time.UTC), false},
+		{"2025-06-01T08:30:00Z", time.Date(2025, 6, 1, 8, 30, 0, 0, time.UTC), false},
+		{"7d", now.AddDate(0, 0, -7), false},
+		{"36h", now.Add(-36 * time.Hour), false},
+		{"last week", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHistoryTime(tt.in, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHistoryTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseHistoryTime(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test the table output shows both locations and flags failures
+func TestPrintHistoryTable(t *testing.T) {
+	var buf bytes.Buffer
+	entries := []HistoryEntry{
+		{Time: time.Now(), Name: "a.pdf", Rule: "PDFs", Action: "move", Source: "/dl/a.pdf", Dest: "/docs/a.pdf"},
+		{Time: time.Now(), Name: "b.zip", Rule: "Archives", Action: "copy", Source: "/dl/b.zip", Error: "boom"},
+	}
+	if err := printHistoryTable(&buf, entries); err != nil {
+		t.Fatalf("printHistoryTable: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"FROM", "/dl/a.pdf", "/docs/a.pdf", "copy (failed)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table output missing %q:\n%s", want, out)
+		}
+	}
+}
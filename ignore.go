@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreConfig lists files downwatch never touches, on top of ignore_exts.
+type IgnoreConfig struct {
+	Globs      []string `yaml:"globs"`                // filepath.Match globs against the base name, e.g. "~$*", ".~lock.*#"
+	Regexes    []string `yaml:"regexes"`              // regular expressions against the base name
+	Hidden     bool     `yaml:"hidden"`               // dotfiles
+	Empty      bool     `yaml:"empty"`                // zero-byte files, until something is written to them
+	YoungerSec int      `yaml:"younger_than_seconds"` // files modified less than this long ago, until they are older; 0 disables
+
+	regexes []*regexp.Regexp
+}
+
+// Reasons a file is ignored
+const (
+	ignoreExt    = "ext"
+	ignoreGlob   = "glob"
+	ignoreRegex  = "regex"
+	ignoreHidden = "hidden"
+	ignoreEmpty  = "empty" // until it is written to
+	ignoreYoung  = "young" // until it is older than younger_than_seconds
+)
+
+func normalizeIgnore(ic *IgnoreConfig) error {
+	for _, g := range ic.Globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return fmt.Errorf("ignore: invalid glob %q: %w", g, err)
+		}
+	}
+	ic.regexes = nil
+	for _, expr := range ic.Regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("ignore: invalid regex %q: %w", expr, err)
+		}
+		ic.regexes = append(ic.regexes, re)
+	}
+	if ic.YoungerSec < 0 {
+		return fmt.Errorf("ignore: younger_than_seconds must not be negative")
+	}
+	return nil
+}
+
+// ignoreReason returns why the file at path (with info fi) should be left
+// alone, or "" if it should be processed. It only looks at the name and fi,
+// so it's cheap enough to run before a file gets a goroutine.
+func (cfg Config) ignoreReason(path string, fi os.FileInfo) string {
+	name := filepath.Base(path)
+	ic := cfg.Ignore
+	switch {
+	case hasIgnoredExt(path, cfg.IgnoreExts):
+		return ignoreExt
+	case anyPatternMatch(name, ic.Globs):
+		return ignoreGlob
+	}
+	for _, re := range ic.regexes {
+		if re.MatchString(name) {
+			return ignoreRegex
+		}
+	}
+	switch {
+	case ic.Hidden && strings.HasPrefix(name, "."):
+		return ignoreHidden
+	case ic.Empty && fi.Size() == 0:
+		return ignoreEmpty
+	case ic.YoungerSec > 0 && time.Since(fi.ModTime()) < time.Duration(ic.YoungerSec)*time.Second:
+		return ignoreYoung
+	}
+	return ""
+}
+
+// Files ignored for now because they are empty or too young (path -> reason).
+// Empty ones are dispatched again when the watcher sees them change, young
+// ones once they are old enough.
+var deferred sync.Map
+
+// deferFile remembers a file ignored for reason ignoreEmpty or ignoreYoung
+// and arranges for it to be dispatched again.
+func (d *daemon) deferFile(path, reason string, fi os.FileInfo, minAge time.Duration) {
+	if _, already := deferred.LoadOrStore(path, reason); already {
+		return
+	}
+	if reason != ignoreYoung {
+		return
+	}
+	time.AfterFunc(minAge-time.Since(fi.ModTime()), func() {
+		if _, ok := deferred.LoadAndDelete(path); ok {
+			d.dispatch(path, false)
+		}
+	})
+}
+
+// wakeDeferred dispatches path if it was ignored for being empty, now that
+// the watcher has seen it change.
+func (d *daemon) wakeDeferred(path string) {
+	if reason, ok := deferred.Load(path); ok && reason == ignoreEmpty {
+		deferred.Delete(path)
+		d.dispatch(path, false)
+	}
+}
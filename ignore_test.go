@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test ignoreReason for each kind of ignore rule
+func TestIgnoreReason(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Ignore = IgnoreConfig{
+		Globs:      []string{"~$*", ".~lock.*#"},
+		Regexes:    []string{`^Unconfirmed \d+`},
+		Hidden:     true,
+		Empty:      true,
+		YoungerSec: 60,
+	}
+	if err := normalizeIgnore(&cfg.Ignore); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	hourAgo := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		content string
+		young   bool
+		want    string
+	}{
+		{"report.pdf", "x", false, ""},
+		{"movie.mkv.part", "x", false, ignoreExt},
+		{"~$budget.xlsx", "x", false, ignoreGlob},
+		{".~lock.budget.ods#", "x", false, ignoreGlob},
+		{".bashrc", "x", false, ignoreHidden},
+		{"Unconfirmed 12345.bin", "x", false, ignoreRegex},
+		{"placeholder.pdf", "", false, ignoreEmpty},
+		{"fresh.pdf", "x", true, ignoreYoung},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name)
+			writeFile(t, p, tt.content)
+			if !tt.young {
+				if err := os.Chtimes(p, hourAgo, hourAgo); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fi, err := os.Stat(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.ignoreReason(p, fi); got != tt.want {
+				t.Errorf("ignoreReason(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test normalizeIgnore rejects bad globs, regexes and ages
+func TestNormalizeIgnore(t *testing.T) {
+	tests := []struct {
+		name    string
+		ic      IgnoreConfig
+		wantErr bool
+	}{
+		{"empty", IgnoreConfig{}, false},
+		{"valid", IgnoreConfig{Globs: []string{"*.tmp"}, Regexes: []string{`^\.#`}}, false},
+		{"bad glob", IgnoreConfig{Globs: []string{"[a-"}}, true},
+		{"bad regex", IgnoreConfig{Regexes: []string{"(unclosed"}}, true},
+		{"negative age", IgnoreConfig{YoungerSec: -5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := normalizeIgnore(&tt.ic); (err != nil) != tt.wantErr {
+				t.Errorf("normalizeIgnore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test ignored files never get a goroutine, and deferred ones are filed once written to or old enough
+func TestDispatchDefersIgnored(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	d.cfg.SettleMillis, d.cfg.PollMillis = 50, 10
+	d.cfg.Ignore = IgnoreConfig{Globs: []string{"~$*"}, Empty: true}
+	watch := d.cfg.WatchDir
+	docs := filepath.Join(filepath.Dir(watch), "docs")
+
+	lock := filepath.Join(watch, "~$report.pdf")
+	empty := filepath.Join(watch, "empty.pdf")
+	writeFile(t, lock, "lock")
+	writeFile(t, empty, "")
+	defer deferred.Delete(empty)
+	if d.job(lock, false) != nil || d.job(empty, false) != nil {
+		t.Fatal("job() returned work for an ignored file")
+	}
+	if reason, _ := deferred.Load(empty); reason != ignoreEmpty {
+		t.Errorf("empty file deferred as %v, want %q", reason, ignoreEmpty)
+	}
+
+	// Written to: the watcher's event wakes it up
+	writeFile(t, empty, "%PDF-1.4")
+	d.wakeDeferred(empty)
+	waitFor(t, filepath.Join(docs, "empty.pdf"))
+
+	// Too young: filed when it comes of age
+	d.mu.Lock()
+	d.cfg.Ignore = IgnoreConfig{YoungerSec: 1}
+	d.mu.Unlock()
+	fresh := filepath.Join(watch, "fresh.pdf")
+	writeFile(t, fresh, "%PDF-1.4")
+	d.dispatch(fresh, false)
+	if exists(filepath.Join(docs, "fresh.pdf")) {
+		t.Fatal("young file filed at once")
+	}
+	waitFor(t, filepath.Join(docs, "fresh.pdf"))
+	if !exists(lock) {
+		t.Error("ignored lock file was touched")
+	}
+}
+
+// waitFor fails the test if path doesn't appear within 5 seconds.
+func waitFor(t *testing.T, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !exists(path); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never appeared", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
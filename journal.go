@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalRecord is one line of the intent journal: an operation starting, or
+// (Done) the operation with the same ID finishing, successfully or not.
+type journalRecord struct {
+	ID   uint64    `json:"id"`
+	Op   string    `json:"op,omitempty"` // "move" or "copy"
+	Src  string    `json:"src,omitempty"`
+	Dst  string    `json:"dst,omitempty"`
+	Tmp  string    `json:"tmp,omitempty"`
+	Time time.Time `json:"time,omitzero"`
+	Done bool      `json:"done,omitempty"`
+}
+
+// intentJournal records copies and cross-filesystem moves before they start,
+// so that recoverJournal can clean up after a crash. The file is emptied
+// whenever no operation is in flight, so it stays a few lines long.
+//
+// Only one process at a time may use a journal file: the daemon, run --once
+// and organize default to the same one, and each would otherwise take the
+// others' in-flight operations for crashed ones. The owner holds an
+// exclusive lock on a sibling ".lock" file for as long as it runs.
+type intentJournal struct {
+	mu     sync.Mutex
+	path   string // empty disables journaling
+	lock   *os.File
+	nextID uint64
+	open   int
+}
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// errJournalBusy means another downwatch process owns the journal file.
+var errJournalBusy = errors.New("journal in use by another downwatch process; journaling disabled")
+
+var moveJournal = &intentJournal{}
+
+// begin records the intent to copy src to dst (and, for a move, remove src).
+// It returns 0 if journaling is off or the record couldn't be written; the
+// operation goes ahead either way.
+func (j *intentJournal) begin(op, src, dst string) uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.path == "" {
+		return 0
+	}
+	j.nextID++
+	rec := journalRecord{ID: j.nextID, Op: op, Src: src, Dst: dst, Tmp: tempPath(dst), Time: time.Now()}
+	if err := j.write(rec); err != nil {
+		slog.Warn("journal write failed", "event", "journal_failed", "file", src, "error", err)
+		return 0
+	}
+	j.open++
+	return rec.ID
+}
+
+// end records that operation id finished.
+func (j *intentJournal) end(id uint64) {
+	if id == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.open--
+	if j.open == 0 {
+		// Nothing in flight: every begin has its end, so start afresh
+		if err := os.Truncate(j.path, 0); err == nil {
+			return
+		}
+	}
+	if err := j.write(journalRecord{ID: id, Done: true}); err != nil {
+		slog.Warn("journal write failed", "event", "journal_failed", "error", err)
+	}
+}
+
+// write appends rec and syncs it to disk. Caller holds j.mu.
+func (j *intentJournal) write(rec journalRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if errDir := os.MkdirAll(filepath.Dir(j.path), 0o700); errDir != nil {
+		return errDir
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, errWrite := f.Write(append(b, '\n')); errWrite != nil {
+		_ = f.Close()
+		return errWrite
+	}
+	if errSync := f.Sync(); errSync != nil {
+		_ = f.Close()
+		return errSync
+	}
+	return f.Close()
+}
+
+// readJournal returns the operations in the journal at path that never finished.
+func readJournal(path string) ([]journalRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var started []journalRecord
+	done := make(map[uint64]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec journalRecord
+		if errDec := json.Unmarshal(sc.Bytes(), &rec); errDec != nil {
+			continue // torn last line from a crash mid-write
+		}
+		if rec.Done {
+			done[rec.ID] = true
+		} else {
+			started = append(started, rec)
+		}
+	}
+	var unfinished []journalRecord
+	for _, rec := range started {
+		if !done[rec.ID] {
+			unfinished = append(unfinished, rec)
+		}
+	}
+	return unfinished, sc.Err()
+}
+
+// recoverJournal cleans up operations interrupted by a crash, then enables
+// journaling to path. A temp file means the copy never reached its final
+// name: it is removed and the source, still in place, is filed again by the
+// initial scan. A move whose copy was renamed into place but whose source
+// survived is completed by removing the source. It returns the number of
+// operations recovered.
+//
+// If another process owns the journal, its operations are in flight rather
+// than interrupted: nothing is recovered, journaling stays off, and
+// errJournalBusy is returned.
+func recoverJournal(path string) (int, error) {
+	return moveJournal.recover(path)
+}
+
+// openJournal recovers the journal at path with recoverJournal and logs the
+// outcome. Every command that files anything calls it before starting.
+func openJournal(path string) {
+	n, err := recoverJournal(path)
+	switch {
+	case errors.Is(err, errJournalBusy):
+		slog.Warn("journal in use by another downwatch process; journaling disabled", "event", "journal_busy", "file", path)
+	case err != nil:
+		slog.Error("journal recovery failed", "event", "recovery_failed", "file", path, "error", err)
+	case n > 0:
+		slog.Info("recovered interrupted operations", "event", "recovered", "files", n)
+	}
+}
+
+func (j *intentJournal) recover(path string) (int, error) {
+	j.close()
+	if path == "" {
+		return 0, nil
+	}
+	lock, err := lockJournal(path)
+	if err != nil {
+		return 0, err
+	}
+	unfinished, err := readJournal(path)
+	if err != nil {
+		_ = lock.Close()
+		return 0, err
+	}
+	recovered := 0
+	for _, rec := range unfinished {
+		if errRec := recoverOperation(rec); errRec != nil {
+			slog.Error("recovery failed", "event", "recovery_failed", "file", rec.Src, "action", rec.Op, "dest", rec.Dst, "error", errRec)
+			continue
+		}
+		recovered++
+	}
+	if errTrunc := os.Truncate(path, 0); errTrunc != nil && !errors.Is(errTrunc, os.ErrNotExist) {
+		_ = lock.Close()
+		return recovered, errTrunc
+	}
+
+	j.mu.Lock()
+	j.path, j.lock = path, lock
+	j.mu.Unlock()
+	return recovered, nil
+}
+
+// close stops journaling and releases the journal lock.
+func (j *intentJournal) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.lock != nil {
+		_ = j.lock.Close()
+	}
+	j.path, j.lock = "", nil
+}
+
+// lockJournal takes the lock on the journal at path, returning
+// errJournalBusy if another process has it.
+func lockJournal(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if errLock := tryLock(f); errLock != nil {
+		_ = f.Close()
+		if errors.Is(errLock, errLocked) {
+			return nil, errJournalBusy
+		}
+		return nil, errLock
+	}
+	return f, nil
+}
+
+func recoverOperation(rec journalRecord) error {
+	if _, err := os.Lstat(rec.Tmp); err == nil {
+		if errRm := os.Remove(rec.Tmp); errRm != nil {
+			return errRm
+		}
+		slog.Info("removed partial copy", "event", "recovered", "file", rec.Src, "action", rec.Op, "dest", rec.Tmp)
+		return nil
+	}
+	if rec.Op != "move" {
+		return nil // a copy without a temp file either finished or never started
+	}
+	dst, errDst := os.Stat(rec.Dst)
+	src, errSrc := os.Stat(rec.Src)
+	if errDst != nil || errSrc != nil {
+		return nil // not copied yet, or the source was already removed
+	}
+	if dst.Size() != src.Size() {
+		return fmt.Errorf("source and destination differ in size (%d vs %d bytes); leaving both", src.Size(), dst.Size())
+	}
+	if errRm := os.Remove(rec.Src); errRm != nil {
+		return errRm
+	}
+	slog.Info("completed interrupted move", "event", "recovered", "file", rec.Src, "action", rec.Op, "dest", rec.Dst)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// Test recoverJournal cleans up each kind of interrupted operation
+func TestRecoverJournal(t *testing.T) {
+	dir := t.TempDir()
+	jpath := filepath.Join(dir, "state", "journal.jsonl")
+	j := &intentJournal{path: jpath}
+	t.Cleanup(moveJournal.close)
+
+	// Move interrupted mid-copy: temp file left, source intact
+	midSrc, midDst := filepath.Join(dir, "watch", "mid.zip"), filepath.Join(dir, "nas", "mid.zip")
+	writeFile(t, midSrc, "full")
+	writeFile(t, tempPath(midDst), "fu")
+	j.begin("move", midSrc, midDst)
+
+	// Move interrupted after the rename: both copies exist
+	lateSrc, lateDst := filepath.Join(dir, "watch", "late.zip"), filepath.Join(dir, "nas", "late.zip")
+	writeFile(t, lateSrc, "data")
+	writeFile(t, lateDst, "data")
+	j.begin("move", lateSrc, lateDst)
+
+	// Copy interrupted mid-copy
+	cpSrc, cpDst := filepath.Join(dir, "watch", "cp.jpg"), filepath.Join(dir, "pics", "cp.jpg")
+	writeFile(t, cpSrc, "jpeg")
+	writeFile(t, tempPath(cpDst), "jp")
+	j.begin("copy", cpSrc, cpDst)
+
+	// Finished move: must be left alone
+	doneSrc, doneDst := filepath.Join(dir, "watch", "done.pdf"), filepath.Join(dir, "docs", "done.pdf")
+	writeFile(t, doneSrc, "a new download with the same name")
+	writeFile(t, doneDst, "pdf")
+	j.end(j.begin("move", doneSrc, doneDst))
+
+	// A torn final line is ignored
+	f, err := os.OpenFile(jpath, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"id":99,"op":"mo`)
+	_ = f.Close()
+
+	n, err := recoverJournal(jpath)
+	if err != nil {
+		t.Fatalf("recoverJournal: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("recovered %d operations, want 3", n)
+	}
+
+	checks := []struct {
+		path string
+		want bool
+	}{
+		{tempPath(midDst), false},
+		{midSrc, true},
+		{midDst, false},
+		{lateSrc, false},
+		{lateDst, true},
+		{tempPath(cpDst), false},
+		{cpSrc, true},
+		{doneSrc, true},
+		{doneDst, true},
+	}
+	for _, c := range checks {
+		if got := exists(c.path); got != c.want {
+			t.Errorf("%s exists = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	if st, err := os.Stat(jpath); err != nil || st.Size() != 0 {
+		t.Errorf("journal not emptied after recovery (err %v)", err)
+	}
+	if moveJournal.path != jpath {
+		t.Errorf("journaling not enabled after recovery")
+	}
+}
+
+// Test a completed copy leaves no temp file and an empty journal
+func TestCopyToJournal(t *testing.T) {
+	dir := t.TempDir()
+	jpath := filepath.Join(dir, "journal.jsonl")
+	if _, err := recoverJournal(jpath); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(moveJournal.close)
+
+	src, dst := filepath.Join(dir, "a.txt"), filepath.Join(dir, "out", "a.txt")
+	writeFile(t, src, "hello")
+	if err := copyTo(src, dst, copyOptions{}); err != nil {
+		t.Fatalf("copyTo: %v", err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "hello" {
+		t.Errorf("dst = %q (err %v)", b, err)
+	}
+	if exists(tempPath(dst)) {
+		t.Error("temp file left behind")
+	}
+	unfinished, err := readJournal(jpath)
+	if err != nil || len(unfinished) != 0 {
+		t.Errorf("journal has unfinished operations %v (err %v)", unfinished, err)
+	}
+	if st, err := os.Stat(jpath); err == nil && st.Size() != 0 {
+		t.Errorf("journal not compacted: %d bytes", st.Size())
+	}
+}
+
+// Test a second process can't recover or journal to a journal in use, and
+// gets it once the owner is done
+func TestJournalLock(t *testing.T) {
+	dir := t.TempDir()
+	jpath := filepath.Join(dir, "journal.jsonl")
+	owner, other := &intentJournal{}, &intentJournal{}
+	t.Cleanup(owner.close)
+	t.Cleanup(other.close)
+	if _, err := owner.recover(jpath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner is mid-copy
+	src, dst := filepath.Join(dir, "watch", "a.zip"), filepath.Join(dir, "nas", "a.zip")
+	writeFile(t, src, "data")
+	writeFile(t, tempPath(dst), "da")
+	id := owner.begin("move", src, dst)
+
+	if _, err := other.recover(jpath); !errors.Is(err, errJournalBusy) {
+		t.Fatalf("second recover error = %v, want errJournalBusy", err)
+	}
+	if !exists(tempPath(dst)) {
+		t.Error("owner's temp file removed by second process")
+	}
+	if other.begin("copy", src, dst) != 0 {
+		t.Error("second process journals while the journal is in use")
+	}
+	if unfinished, err := readJournal(jpath); err != nil || len(unfinished) != 1 {
+		t.Errorf("owner's record lost: %v (err %v)", unfinished, err)
+	}
+
+	owner.end(id)
+	owner.close()
+	if _, err := other.recover(jpath); err != nil {
+		t.Errorf("recover after owner closed: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock isn't implemented here; every process gets the lock.
+func tryLock(*os.File) error { return nil }
+
+// connRefused reports whether err is a refused connection, i.e. nothing is
+// listening on the socket.
+func connRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
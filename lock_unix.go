@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// tryLock takes an exclusive advisory lock on f without waiting. It returns
+// errLocked if another process (or another open of the file) holds it.
+func tryLock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
+
+// connRefused reports whether err is a refused connection, i.e. nothing is
+// listening on the socket.
+func connRefused(err error) bool {
+	return errors.Is(err, unix.ECONNREFUSED)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLock takes an exclusive lock on the first byte of f without waiting. It
+// returns errLocked if another process (or another open of the file) holds it.
+func tryLock(f *os.File) error {
+	var ol windows.Overlapped
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+// connRefused reports whether err is a refused connection, i.e. nothing is
+// listening on the socket.
+func connRefused(err error) bool {
+	return errors.Is(err, windows.WSAECONNREFUSED)
+}
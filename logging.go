@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log records share these attribute keys so a log pipeline can rely on them:
+//
+//	event    machine-readable event type, e.g. "moved", "move_failed", "skip_unstable"
+//	file     base filename being processed
+//	rule     name of the matching rule
+//	action   rule action ("move" or "copy")
+//	dest     destination path or WebDAV prefix
+//	bytes    file size
+//	duration time since the file was picked up
+//	error    error message for failures
+
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log_level %q (want debug, info, warn or error)", s)
+}
+
+// textLogger is slog's original default, which writes through the log
+// package. slog.Default can't be used after a reload: by then it may be the
+// JSON logger installed by setupLogging.
+var textLogger = slog.Default()
+
+// newLogger builds the process logger. With log_json the output is one JSON
+// object per line and durations are reported as seconds; otherwise records go
+// through the standard log package to w as
+// "2006/01/02 15:04:05 INFO msg key=value".
+func newLogger(w io.Writer, jsonOutput bool, level slog.Level) *slog.Logger {
+	if !jsonOutput {
+		// Installing a JSON logger as the default pointed the log package
+		// at it and cleared its flags; undo that
+		log.SetOutput(w)
+		log.SetFlags(log.LstdFlags)
+		slog.SetLogLoggerLevel(level)
+		return textLogger
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindDuration {
+				return slog.Float64(a.Key, a.Value.Duration().Seconds())
+			}
+			return a
+		},
+	}))
+}
+
+// setupLogging installs the logger described by cfg as the slog default.
+// loadConfig has already validated LogLevel.
+func setupLogging(cfg Config) {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	slog.SetDefault(newLogger(os.Stderr, cfg.LogJSON, level))
+}
+
+// fatal logs msg at error level and exits, replacing log.Fatal for structured output.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test parseLogLevel accepted values
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"info", slog.LevelInfo, false},
+		{"DEBUG", slog.LevelDebug, false},
+		{"warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", slog.LevelInfo, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseLogLevel(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLogLevel(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLogLevel(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test JSON logger emits the documented fields with durations in seconds
+func TestNewLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, true, slog.LevelInfo)
+
+	logger.Debug("hidden", "event", "skip_ignored")
+	logger.Error("move failed", "event", "move_failed", "file", "a.pdf", "rule", "PDFs",
+		"bytes", int64(10), "duration", 1500*time.Millisecond, "error", errors.New("disk full"))
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected exactly one JSON record, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level":    "ERROR",
+		"event":    "move_failed",
+		"file":     "a.pdf",
+		"rule":     "PDFs",
+		"bytes":    float64(10),
+		"duration": 1.5,
+		"error":    "disk full",
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("field %q = %v, want %v", k, rec[k], v)
+		}
+	}
+}
+
+// Test the logger switches back from JSON to text output, as on a reload
+func TestNewLoggerJSONToText(t *testing.T) {
+	orig := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(orig)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	var buf bytes.Buffer
+	slog.SetDefault(newLogger(&buf, true, slog.LevelInfo))
+	slog.Info("json on", "event", "x")
+	if !strings.HasPrefix(buf.String(), "{") {
+		t.Fatalf("log_json output = %q, want JSON", buf.String())
+	}
+
+	buf.Reset()
+	slog.SetDefault(newLogger(&buf, false, slog.LevelInfo))
+	slog.Info("json off", "event", "y")
+	got := buf.String()
+	if strings.HasPrefix(got, "{") || !strings.Contains(got, "INFO json off event=y") {
+		t.Errorf("text output = %q, want a log line with INFO json off event=y", got)
+	}
+	if _, err := time.Parse("2006/01/02 15:04:05", got[:min(len(got), 19)]); err != nil {
+		t.Errorf("text output = %q, want a date and time prefix", got)
+	}
+}
@@ -2,15 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -22,7 +22,7 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Track files currently being processed to avoid duplicate handling
+// Track files currently being processed to avoid duplicate handling (path -> *fileState)
 var processing sync.Map
 
 type Rule struct {
@@ -31,10 +31,33 @@ type Rule struct {
 	Extensions     []string `yaml:"extensions"`      // like ["pdf","zip","jpg"], case-insensitive, no leading dot
 	MIMEPrefixes   []string `yaml:"mime_prefixes"`   // e.g. ["image/","video/","application/pdf"]
 	Action         string   `yaml:"action"`          // "move" (default) or "copy"
-	Dest           string   `yaml:"dest"`            // destination directory (supports ~ expansion); for iCloud Drive, see notes below
+	Dest           string   `yaml:"dest"`            // destination directory (supports ~ expansion and placeholders such as {origin.host}); for iCloud Drive, see notes below
 	SkipDuplicates bool     `yaml:"skip_duplicates"` // if true, delete source (move) or skip (copy) when duplicate exists
 	WebDAVUpload   bool     `yaml:"webdav_upload"`   // if true, also upload to DAV
 	WebDAVPath     string   `yaml:"webdav_path"`     // remote path prefix (e.g. "/inbox/") for DAV upload
+	WebhookEvents  []string `yaml:"webhook_events"`  // events sent to webhooks for this rule: "success", "duplicate", "failure"; default all
+	Notify         *bool    `yaml:"notify"`          // desktop notifications for this rule; default true when notifications are on
+	VerifyMoves    *bool    `yaml:"verify_moves"`    // overrides the global verify_moves for this rule
+	MinFreeMB      int64    `yaml:"min_free_mb"`     // keep this much free on the destination after filing; default 0
+	FallbackRule   string   `yaml:"fallback_rule"`   // rule to file with instead when the destination is too full; otherwise the file is held
+
+	// Download origin, from the user.xdg.origin.url and user.xdg.referrer.url
+	// xattrs; files without them never match
+	OriginHosts   []string `yaml:"origin_hosts"`   // host globs, e.g. ["jira.example.com", "*.atlassian.net"]
+	OriginURLs    []string `yaml:"origin_urls"`    // URL patterns where * matches anything, e.g. ["https://jira.example.com/secure/attachment/*"]
+	ReferrerHosts []string `yaml:"referrer_hosts"` // like origin_hosts, for the page the download was started from
+	ReferrerURLs  []string `yaml:"referrer_urls"`  // like origin_urls, for the referring page
+
+	// Photo metadata (EXIF in JPEG, TIFF and HEIC); files without EXIF never match
+	CameraMakes  []string `yaml:"camera_makes"`  // globs against the camera make, case-insensitive, e.g. ["Google", "FUJIFILM"]
+	CameraModels []string `yaml:"camera_models"` // globs against the camera model, e.g. ["Pixel 8", "iPhone 1*"]
+	HasGPS       *bool    `yaml:"has_gps"`       // match photos with (true) or without (false) a GPS position
+
+	// Stability overrides for files this rule matches (matched by name or
+	// MIME type before the download is complete)
+	SettleMillis *int `yaml:"settle_millis"`    // overrides the global settle_millis; 0 files them at once
+	PollMillis   *int `yaml:"poll_millis"`      // overrides the global poll_millis
+	MaxWaitSec   *int `yaml:"max_wait_seconds"` // overrides the global max_wait_seconds; 0 waits as long as it takes
 }
 
 type WebDAVConfig struct {
@@ -46,15 +69,34 @@ type WebDAVConfig struct {
 }
 
 type Config struct {
-	WatchDir       string       `yaml:"watch_dir"` // default: ~/Downloads
-	Rules          []Rule       `yaml:"rules"`
-	IgnoreExts     []string     `yaml:"ignore_exts"`   // default: [".crdownload",".download",".part",".partial"]
-	SettleMillis   int          `yaml:"settle_millis"` // stability window before acting; default 1500
-	PollMillis     int          `yaml:"poll_millis"`   // interval for size checks; default 250
-	WebDAV         WebDAVConfig `yaml:"webdav"`
-	LogJSON        bool         `yaml:"log_json"`         // future hook; currently plain log
-	CreateDestDirs bool         `yaml:"create_dest_dirs"` // default true
-	Notifications  bool         `yaml:"notifications"`    // show macOS notifications; default true
+	WatchDir       string          `yaml:"watch_dir"`                // default: ~/Downloads
+	WatchBackend   string          `yaml:"watch_backend"`            // how watch_dir (the only watched directory) is watched: "fsnotify", "poll" or "auto"; default fsnotify
+	WatchPollSec   int             `yaml:"watch_poll_seconds"`       // listing interval for the poll backend; default 5
+	ScanOrder      string          `yaml:"initial_scan_order"`       // order existing files are filed in at startup: "oldest" or "newest" mtime first; default oldest
+	ScanOldSec     int             `yaml:"initial_scan_old_seconds"` // at startup, files unmodified this long skip the stability wait; 0 skips it for all; default 60
+	Rules          []Rule          `yaml:"rules"`
+	IgnoreExts     []string        `yaml:"ignore_exts"`      // default: [".crdownload",".download",".part",".partial"]
+	Ignore         IgnoreConfig    `yaml:"ignore"`           // globs, regexes, hidden, empty and young files to leave alone
+	SettleMillis   int             `yaml:"settle_millis"`    // stability window before acting; default 1500
+	PollMillis     int             `yaml:"poll_millis"`      // interval for size checks; default 250
+	Stability      []string        `yaml:"stability_checks"` // what must hold still for settle_millis: "size", "mtime", "writers"; default all
+	MaxWaitSec     int             `yaml:"max_wait_seconds"` // give up waiting for stability after this long and re-queue the file; 0 never gives up; default 300
+	WebDAV         WebDAVConfig    `yaml:"webdav"`
+	LogJSON        bool            `yaml:"log_json"`              // structured JSON logs on stderr instead of plain text
+	LogLevel       string          `yaml:"log_level"`             // debug, info, warn or error; default info
+	CreateDestDirs bool            `yaml:"create_dest_dirs"`      // default true
+	Notifications  bool            `yaml:"notifications"`         // show desktop notifications (macOS, Linux D-Bus); default true
+	NotifyDigest   int             `yaml:"notify_digest_seconds"` // coalesce notifications over this window; 0 notifies per file; default 10
+	HistoryFile    string          `yaml:"history_file"`          // processed-file log for `downwatch history`; empty disables
+	JournalFile    string          `yaml:"journal_file"`          // intent journal for recovering interrupted copies at startup; empty disables
+	HoldRetrySec   int             `yaml:"hold_retry_seconds"`    // how often files held for lack of space are retried; default 60
+	RescanSec      int             `yaml:"rescan_seconds"`        // how often to look for matching files the watcher missed; 0 disables; default 300
+	MetricsListen  string          `yaml:"metrics_listen"`        // address for the Prometheus /metrics endpoint, e.g. "127.0.0.1:9101"; empty disables
+	APIListen      string          `yaml:"api_listen"`            // status/control API: "127.0.0.1:7878" or "unix:/path/to.sock"; empty disables
+	Webhooks       []WebhookConfig `yaml:"webhooks"`              // HTTP endpoints notified of filing events
+	Email          EmailConfig     `yaml:"email"`                 // SMTP notifications or daily report; disabled without host
+	VerifyMoves    bool            `yaml:"verify_moves"`          // hash source and copy on cross-filesystem moves before removing the source
+	Preserve       PreserveConfig  `yaml:"preserve"`              // file attributes kept on copies and cross-filesystem moves
 }
 
 func expandHome(p string) (string, error) {
@@ -77,11 +119,24 @@ func expandHome(p string) (string, error) {
 func defaultConfig() Config {
 	return Config{
 		WatchDir:       "~/Downloads",
+		WatchBackend:   backendFsnotify,
+		WatchPollSec:   5,
+		ScanOrder:      scanOldest,
+		ScanOldSec:     60,
 		IgnoreExts:     []string{".crdownload", ".download", ".part", ".partial"},
 		SettleMillis:   1500,
 		PollMillis:     250,
+		Stability:      []string{checkSize, checkMtime, checkWriters},
+		MaxWaitSec:     300,
 		CreateDestDirs: true,
 		Notifications:  true,
+		NotifyDigest:   10,
+		Preserve:       PreserveConfig{Mode: true, Times: true, Owner: true, Xattrs: true},
+		LogLevel:       "info",
+		HistoryFile:    "~/.local/state/downwatch/history.jsonl",
+		JournalFile:    "~/.local/state/downwatch/journal.jsonl",
+		HoldRetrySec:   60,
+		RescanSec:      300,
 		WebDAV: WebDAVConfig{
 			TimeoutSec: 30,
 		},
@@ -119,29 +174,6 @@ func hasIgnoredExt(path string, ignores []string) bool {
 	return false
 }
 
-// notifyUser sends a macOS native notification using osascript.
-// Only works on macOS; silently fails on other platforms.
-// Runs asynchronously to avoid blocking file processing.
-func notifyUser(title, message string) {
-	if runtime.GOOS != "darwin" {
-		return
-	}
-
-	// Escape quotes in strings for AppleScript
-	title = strings.ReplaceAll(title, `"`, `\"`)
-	message = strings.ReplaceAll(message, `"`, `\"`)
-
-	script := fmt.Sprintf(`display notification %q with title %q`, message, title)
-	cmd := exec.Command("osascript", "-e", script)
-
-	// Run async in goroutine to avoid blocking
-	go func() {
-		if err := cmd.Run(); err != nil {
-			log.Printf("notification failed: %v", err)
-		}
-	}()
-}
-
 func anyPatternMatch(name string, patterns []string) bool {
 	if len(patterns) == 0 {
 		return false
@@ -180,71 +212,105 @@ func mimePrefixMatches(mt string, prefixes []string) bool {
 	return false
 }
 
+// chooseRule returns the first rule matching path. The name matchers
+// (patterns, extensions, mime_prefixes) are alternatives to each other; the
+// metadata matchers narrow them down, so a rule with extensions and
+// origin_hosts only takes files that match both.
 func chooseRule(path string, rules []Rule) *Rule {
 	base := filepath.Base(path)
 	mt := detectMIME(path)
+	meta := newFileMeta(path)
 
 	for i := range rules {
 		r := &rules[i]
-		if anyPatternMatch(base, r.Patterns) || extMatches(base, r.Extensions) || mimePrefixMatches(mt, r.MIMEPrefixes) {
+		if r.hasNameMatchers() {
+			if !anyPatternMatch(base, r.Patterns) && !extMatches(base, r.Extensions) && !mimePrefixMatches(mt, r.MIMEPrefixes) {
+				continue
+			}
+		} else if !r.hasMetaMatchers() {
+			continue
+		}
+		if r.metaMatches(meta) {
 			return r
 		}
 	}
 	return nil
 }
 
-func waitUntilStable(path string, settle time.Duration, poll time.Duration) error {
-	// Consider stable when size is unchanged across the settle window.
-	deadline := time.Now().Add(5 * time.Minute) // safety
-	var lastSize int64 = -1
-	var stableFor time.Duration
-
-	for time.Now().Before(deadline) {
-		fi, err := os.Stat(path)
-		if err != nil {
-			return err
-		}
-		size := fi.Size()
-		if size == lastSize {
-			stableFor += poll
-			if stableFor >= settle {
-				return nil
-			}
-		} else {
-			lastSize = size
-			stableFor = 0
-		}
-		time.Sleep(poll)
-	}
-	return errors.New("file did not stabilize within 5 minutes")
+// hasNameMatchers reports whether r matches on file name or MIME type.
+func (r *Rule) hasNameMatchers() bool {
+	return len(r.Patterns) > 0 || len(r.Extensions) > 0 || len(r.MIMEPrefixes) > 0
 }
 
 func ensureDir(dir string) error {
 	return os.MkdirAll(dir, 0o755)
 }
 
-func atomicMove(src, dst string) error {
+// copyOptions controls how atomicMove's copy fallback and copyTo write files.
+type copyOptions struct {
+	verify   bool           // compare SHA-256 of source and copy before removing the source
+	preserve PreserveConfig // attributes carried over to the copy
+}
+
+// copyOptions returns the options for filing with rule r.
+func (cfg Config) copyOptions(r *Rule) copyOptions {
+	verify := cfg.VerifyMoves
+	if r != nil && r.VerifyMoves != nil {
+		verify = *r.VerifyMoves
+	}
+	return copyOptions{verify: verify, preserve: cfg.Preserve}
+}
+
+func atomicMove(src, dst string, opts copyOptions) error {
 	// Try rename first (same filesystem)
 	if err := os.Rename(src, dst); err == nil {
 		return nil
 	}
-	// Cross-filesystem: copy then remove
+	return moveByCopy(src, dst, opts)
+}
+
+// moveByCopy moves src across filesystems by copying it with writeCopy and
+// then removing src. The move is journaled so an interrupted one can be
+// finished at the next startup.
+func moveByCopy(src, dst string, opts copyOptions) error {
+	id := moveJournal.begin("move", src, dst)
+	defer moveJournal.end(id)
+	if err := writeCopy(src, dst, opts); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// writeCopy copies src to a temp file next to dst, syncs it, renames it into
+// place and syncs the directory, so dst is either absent or complete even
+// after a power cut. With opts.verify the temp file is re-read and its hash
+// compared with the source's before it is renamed.
+func writeCopy(src, dst string, opts copyOptions) error {
 	sf, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = sf.Close() }()
+	st, err := sf.Stat() // before reading, for the original access time
+	if err != nil {
+		return err
+	}
 
 	if errDir := ensureDir(filepath.Dir(dst)); errDir != nil {
 		return errDir
 	}
 
-	df, err := os.Create(dst + ".tmp")
+	df, err := os.Create(tempPath(dst))
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(df, sf); err != nil {
+	srcHash := sha256.New()
+	var r io.Reader = sf
+	if opts.verify {
+		r = io.TeeReader(sf, srcHash)
+	}
+	if _, err := io.Copy(df, r); err != nil {
 		_ = df.Close()
 		_ = os.Remove(df.Name())
 		return err
@@ -258,37 +324,78 @@ func atomicMove(src, dst string) error {
 		_ = os.Remove(df.Name())
 		return err
 	}
+	if opts.verify {
+		if err := verifyCopy(df.Name(), srcHash.Sum(nil)); err != nil {
+			_ = os.Remove(df.Name())
+			return err
+		}
+	}
+	warnPreserve(src, st, df.Name(), opts.preserve)
 	if err := os.Rename(df.Name(), dst); err != nil {
 		_ = os.Remove(df.Name())
 		return err
 	}
-	return os.Remove(src)
+	return syncDir(filepath.Dir(dst))
 }
 
-func copyTo(src, dst string) error {
-	sf, err := os.Open(src)
+// tempPath is where writeCopy stages dst: a hidden file in the same
+// directory, named so leftovers are recognisable as downwatch's.
+func tempPath(dst string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".downwatch-tmp")
+}
+
+// verifyCopy re-reads the file at path and compares its SHA-256 with want.
+func verifyCopy(path string, want []byte) error {
+	got, err := hashFile(path)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = sf.Close() }()
-
-	if errDir := ensureDir(filepath.Dir(dst)); errDir != nil {
-		return errDir
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("checksum mismatch: source sha256 %x, copy sha256 %x", want, got)
 	}
+	return nil
+}
 
-	df, err := os.Create(dst)
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, err := io.Copy(df, sf); err != nil {
-		_ = df.Close()
-		return err
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
 	}
-	if err := df.Sync(); err != nil {
-		_ = df.Close()
+	return h.Sum(nil), nil
+}
+
+// syncDir flushes a directory's entries to disk so a rename into it survives
+// a power cut. Windows can't open directories for syncing, so it's a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
 		return err
 	}
-	return df.Close()
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+func copyTo(src, dst string, opts copyOptions) error {
+	id := moveJournal.begin("copy", src, dst)
+	defer moveJournal.end(id)
+	opts.verify = false // verify_moves guards deleting the source; a copy keeps it
+	return writeCopy(src, dst, opts)
+}
+
+// warnPreserve applies preserveAttrs, logging rather than failing: the data
+// is safely copied even if some attributes couldn't be.
+func warnPreserve(src string, st os.FileInfo, dst string, p PreserveConfig) {
+	if err := preserveAttrs(src, st, dst, p); err != nil {
+		slog.Warn("could not preserve file attributes", "event", "preserve_failed", "file", src, "dest", dst, "error", err)
+	}
 }
 
 func uniquePath(dst string) string {
@@ -403,6 +510,16 @@ func loadConfig(path string) (Config, error) {
 		}
 		cfg.Rules[i].Dest = d
 	}
+	hf, err := expandHome(cfg.HistoryFile)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.HistoryFile = hf
+	jf, err := expandHome(cfg.JournalFile)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.JournalFile = jf
 	// Sanitize rule actions
 	for i := range cfg.Rules {
 		a := strings.ToLower(strings.TrimSpace(cfg.Rules[i].Action))
@@ -413,6 +530,45 @@ func loadConfig(path string) (Config, error) {
 			return Config{}, fmt.Errorf("rule %q has invalid action %q", cfg.Rules[i].Name, cfg.Rules[i].Action)
 		}
 		cfg.Rules[i].Action = a
+		if errEvents := normalizeWebhookEvents(cfg.Rules[i].WebhookEvents); errEvents != nil {
+			return Config{}, fmt.Errorf("rule %q: %w", cfg.Rules[i].Name, errEvents)
+		}
+		if errDest := validateDest(cfg.Rules[i].Dest); errDest != nil {
+			return Config{}, fmt.Errorf("rule %q: %w", cfg.Rules[i].Name, errDest)
+		}
+		if errMeta := validateMetaMatchers(cfg.Rules[i]); errMeta != nil {
+			return Config{}, fmt.Errorf("rule %q: %w", cfg.Rules[i].Name, errMeta)
+		}
+	}
+	if errChecks := normalizeStabilityChecks(cfg.Stability); errChecks != nil {
+		return Config{}, errChecks
+	}
+	if errTimes := validateStabilityTimes(cfg); errTimes != nil {
+		return Config{}, errTimes
+	}
+	if errIgnore := normalizeIgnore(&cfg.Ignore); errIgnore != nil {
+		return Config{}, errIgnore
+	}
+	if errScan := normalizeInitialScan(&cfg); errScan != nil {
+		return Config{}, errScan
+	}
+	if errBackend := normalizeWatchBackend(&cfg); errBackend != nil {
+		return Config{}, errBackend
+	}
+	if errFallback := validateFallbacks(cfg.Rules); errFallback != nil {
+		return Config{}, errFallback
+	}
+	if cfg.HoldRetrySec <= 0 {
+		cfg.HoldRetrySec = defaultConfig().HoldRetrySec
+	}
+	if errHooks := normalizeWebhooks(cfg.Webhooks); errHooks != nil {
+		return Config{}, errHooks
+	}
+	if errEmail := normalizeEmail(&cfg.Email); errEmail != nil {
+		return Config{}, errEmail
+	}
+	if _, errLevel := parseLogLevel(cfg.LogLevel); errLevel != nil {
+		return Config{}, errLevel
 	}
 	// Normalize ignore exts
 	if len(cfg.IgnoreExts) == 0 {
@@ -421,160 +577,341 @@ func loadConfig(path string) (Config, error) {
 	return cfg, nil
 }
 
-func handleFile(path string, cfg Config, dav *gowebdav.Client, skipStabilityCheck bool) {
-	// Check if this file is already being processed
-	if _, exists := processing.LoadOrStore(path, time.Now()); exists {
-		return // Already being handled by another goroutine
-	}
-	defer processing.Delete(path)
+// fileResult is what handleFile did with a file.
+type fileResult struct {
+	Status string // one of the result* constants
+	Rule   string // matched rule, if any
+	Action string // "move", "copy", "delete" or "skip" once a rule was applied
+	Dest   string // destination file, or directory for duplicates
+	Err    error  // why it failed or was skipped
+}
+
+// handleFile outcomes
+const (
+	resultFiled     = "filed"     // moved or copied
+	resultDuplicate = "duplicate" // duplicate source deleted or copy skipped
+	resultUnmatched = "unmatched" // no rule matched
+	resultSkipped   = "skipped"   // ignored, gone, already in progress or never stable
+	resultHeld      = "held"      // not enough free space at the destination
+	resultFailed    = "failed"
+)
+
+var (
+	errInProgress  = errors.New("already being processed")
+	errInDest      = errors.New("already in its destination")
+	errNoDest      = errors.New("rule has no dest")
+	errDownloading = errors.New("browser download in progress")
+)
+
+// handleOptions adjusts how handleFile treats a file.
+type handleOptions struct {
+	skipStability bool // the file is known to be complete (initial scan, organize)
+	dryRun        bool // decide what would happen, but don't touch the file or record anything
+	watched       bool // the watcher reports changes to the file (touchFile), so settle on those instead of polling
+}
 
-	// Ignore directories and hidden temp files
+func handleFile(path string, cfg Config, dav *gowebdav.Client, opts handleOptions) fileResult {
+	name := filepath.Base(path)
+	// Ignore directories and ignored files before taking a processing slot
 	st, err := os.Stat(path)
 	if err != nil || st.IsDir() {
-		return
-	}
-	if hasIgnoredExt(path, cfg.IgnoreExts) {
-		log.Printf("skip (ignored ext): %s", filepath.Base(path))
-		return
-	}
-	// wait for stability (skip for existing files during initial scan)
-	if !skipStabilityCheck {
-		settle := time.Duration(cfg.SettleMillis) * time.Millisecond
-		poll := time.Duration(cfg.PollMillis) * time.Millisecond
-		if err := waitUntilStable(path, settle, poll); err != nil {
-			log.Printf("skip (not stable): %s (%v)", filepath.Base(path), err)
-			return
+		return fileResult{Status: resultSkipped, Err: err}
+	}
+	if reason := cfg.ignoreReason(path, st); reason != "" {
+		slog.Debug("skip (ignored)", "event", "skip_ignored", "file", name, "reason", reason)
+		return fileResult{Status: resultSkipped}
+	}
+
+	// Check if this file is already being processed
+	state := newFileState()
+	if _, exists := processing.LoadOrStore(path, state); exists {
+		return fileResult{Status: resultSkipped, Err: errInProgress} // Already being handled by another goroutine
+	}
+	defer processing.Delete(path)
+	start := state.started
+	if isUndone(path, st) {
+		slog.Debug("skip (undone)", "event", "skip_undone", "file", name)
+		return fileResult{Status: resultSkipped}
+	}
+	events.publish(FileEvent{Type: eventDetected, Path: path, Bytes: st.Size()})
+	// wait for stability (skip for existing files during initial scan), unless
+	// a browser is still downloading over this file
+	wait := !opts.skipStability
+	if side, _ := findSidecar(path, st, cfg.IgnoreExts); side != "" && !wait {
+		if opts.dryRun {
+			return fileResult{Status: resultSkipped, Err: errDownloading}
+		}
+		wait = true
+	}
+	if wait {
+		events.publish(FileEvent{Type: eventStabilizing, Path: path})
+		state.setStage(stageStabilizing)
+		metricQueueDepth.Inc()
+		// The rule that matches now picks the settings; it is matched again
+		// once the file is complete, as its content may change the result
+		early := chooseRule(path, cfg.Rules)
+		sc := cfg.stability(early)
+		if opts.watched {
+			sc.touched = state.touched
+		}
+		errStable := waitUntilStable(path, sc)
+		metricQueueDepth.Dec()
+		metricStabilityWait.Observe(time.Since(start).Seconds())
+		if errStable != nil {
+			slog.Warn("skip (not stable)", "event", "skip_unstable", "file", name, "duration", time.Since(start), "error", errStable)
+			if errors.Is(errStable, errNotStable) {
+				// Still changing after max_wait_seconds: alert like any failure
+				entry := HistoryEntry{Name: name, Action: "stability", Source: path, Error: errStable.Error()}
+				if early != nil {
+					entry.Rule = early.Name
+				}
+				if fi, errSize := os.Stat(path); errSize == nil {
+					entry.Size = fi.Size()
+				}
+				recordOperation(cfg, entry)
+			} else {
+				metricFailures.WithLabelValues("stability").Inc()
+				events.publish(FileEvent{Type: eventFailed, Path: path, Stage: "stability", Error: errStable.Error()})
+			}
+			return fileResult{Status: resultSkipped, Err: errStable}
 		}
 	}
 
+	state.setStage(stageMatching)
 	r := chooseRule(path, cfg.Rules)
 	if r == nil {
-		log.Printf("no rule matched: %s", filepath.Base(path))
-		return
+		slog.Info("no rule matched", "event", "no_match", "file", name)
+		return fileResult{Status: resultUnmatched}
+	}
+	roomy := ruleWithSpace(path, r, cfg.Rules)
+	if roomy == nil {
+		if !opts.dryRun {
+			holdFile(path, r)
+		}
+		return fileResult{Status: resultHeld, Rule: r.Name, Action: r.Action, Dest: r.Dest}
 	}
+	if !opts.dryRun {
+		releaseFile(path)
+	}
+	r = roomy
+
+	destDir := expandDest(r.Dest, path)
+	events.publish(FileEvent{Type: eventMatched, Path: path, Rule: r.Name, Action: r.Action, Dest: destDir})
 
-	destDir := r.Dest
 	if destDir == "" {
-		log.Printf("rule %q has empty dest; skipping %s", r.Name, filepath.Base(path))
-		return
+		slog.Warn("rule has empty dest; skipping", "event", "skip_no_dest", "file", name, "rule", r.Name)
+		return fileResult{Status: resultSkipped, Rule: r.Name, Err: errNoDest}
 	}
-	if cfg.CreateDestDirs {
+	if filepath.Clean(filepath.Dir(path)) == filepath.Clean(destDir) {
+		slog.Debug("skip (already in dest)", "event", "skip_in_dest", "file", name, "rule", r.Name)
+		return fileResult{Status: resultSkipped, Rule: r.Name, Err: errInDest}
+	}
+	if cfg.CreateDestDirs && !opts.dryRun {
 		if err := ensureDir(destDir); err != nil {
-			log.Printf("dest mkdir failed: %v", err)
-			return
+			metricFailures.WithLabelValues("mkdir").Inc()
+			events.publish(FileEvent{Type: eventFailed, Path: path, Rule: r.Name, Dest: destDir, Stage: "mkdir", Error: err.Error()})
+			slog.Error("dest mkdir failed", "event", "mkdir_failed", "file", name, "rule", r.Name, "dest", destDir, "error", err)
+			return fileResult{Status: resultFailed, Rule: r.Name, Dest: destDir, Err: err}
 		}
 	}
 
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	entry := HistoryEntry{Name: name, Rule: r.Name, Source: path, Size: size}
+	state.setStage(stageFiling)
+	logger := slog.With("file", name, "rule", r.Name, "action", r.Action, "bytes", size)
+
 	// Check for duplicates if skip_duplicates is enabled
 	if r.SkipDuplicates {
 		if fileExistsWithSameSize(path, destDir) {
+			entry.Dest = destDir
+			if opts.dryRun {
+				action := "skip"
+				if r.Action == "move" {
+					action = "delete"
+				}
+				return fileResult{Status: resultDuplicate, Rule: r.Name, Action: action, Dest: destDir}
+			}
 			if r.Action == "move" {
 				// Delete source file when duplicate exists
+				entry.Action = "delete"
 				if err := os.Remove(path); err != nil {
-					log.Printf("failed to delete duplicate source: %v", err)
-					return
+					logger.Error("failed to delete duplicate source", "event", "delete_failed", "dest", destDir, "error", err)
+					entry.Error = err.Error()
+					recordOperation(cfg, entry)
+					return fileResult{Status: resultFailed, Rule: r.Name, Action: entry.Action, Dest: destDir, Err: err}
 				}
-				log.Printf("deleted (duplicate): %s (rule: %s)", filepath.Base(path), r.Name)
+				logger.Info("deleted (duplicate)", "event", "duplicate_deleted", "dest", destDir, "duration", time.Since(start))
 			} else {
 				// Skip for copy action
-				log.Printf("skip (already exists): %s (rule: %s)", filepath.Base(path), r.Name)
+				entry.Action = "skip"
+				logger.Info("skip (already exists)", "event", "duplicate_skipped", "dest", destDir, "duration", time.Since(start))
 			}
-			return
+			recordOperation(cfg, entry)
+			return fileResult{Status: resultDuplicate, Rule: r.Name, Action: entry.Action, Dest: destDir}
 		}
 	}
 
-	dst := filepath.Join(destDir, filepath.Base(path))
+	dst := filepath.Join(destDir, name)
 	if _, err := os.Stat(dst); err == nil {
 		dst = uniquePath(dst)
 	}
+	entry.Action = r.Action
+	entry.Dest = dst
+	if opts.dryRun {
+		return fileResult{Status: resultFiled, Rule: r.Name, Action: r.Action, Dest: dst}
+	}
 
 	switch r.Action {
 	case "move":
-		if err := atomicMove(path, dst); err != nil {
-			log.Printf("move failed: %v", err)
-			return
-		}
-		log.Printf("moved: %s -> %s (rule: %s)", filepath.Base(path), destDir, r.Name)
-		if cfg.Notifications {
-			notifyUser("downwatch", fmt.Sprintf("Moved %s to %s", filepath.Base(path), destDir))
+		if err := atomicMove(path, dst, cfg.copyOptions(r)); err != nil {
+			logger.Error("move failed", "event", "move_failed", "dest", dst, "error", err)
+			entry.Error = err.Error()
+			recordOperation(cfg, entry)
+			return fileResult{Status: resultFailed, Rule: r.Name, Action: r.Action, Dest: dst, Err: err}
 		}
+		logger.Info("moved", "event", "moved", "dest", dst, "duration", time.Since(start))
 	case "copy":
-		if err := copyTo(path, dst); err != nil {
-			log.Printf("copy failed: %v", err)
-			return
-		}
-		log.Printf("copied: %s -> %s (rule: %s)", filepath.Base(path), destDir, r.Name)
-		if cfg.Notifications {
-			notifyUser("downwatch", fmt.Sprintf("Copied %s to %s", filepath.Base(path), destDir))
+		if err := copyTo(path, dst, cfg.copyOptions(r)); err != nil {
+			logger.Error("copy failed", "event", "copy_failed", "dest", dst, "error", err)
+			entry.Error = err.Error()
+			recordOperation(cfg, entry)
+			return fileResult{Status: resultFailed, Rule: r.Name, Action: r.Action, Dest: dst, Err: err}
 		}
+		logger.Info("copied", "event", "copied", "dest", dst, "duration", time.Since(start))
 	default:
 		// unreachable due to validation
 	}
+	recordOperation(cfg, entry)
+	result := fileResult{Status: resultFiled, Rule: r.Name, Action: r.Action, Dest: dst}
 
 	// Optional DAV upload
 	if r.WebDAVUpload && dav != nil {
+		state.setStage(stageUploading)
 		timeout := time.Duration(cfg.WebDAV.TimeoutSec) * time.Second
 		target := dst
 		// If action == copy, upload the original path to avoid double-read? Either is fine.
 		// Use dst so we upload exactly what we filed.
-		if err := davUpload(dav, target, r.WebDAVPath, timeout); err != nil {
-			log.Printf("webdav upload failed: %v", err)
+		uploadStart := time.Now()
+		errUpload := davUpload(dav, target, r.WebDAVPath, timeout)
+		metricUploadDuration.Observe(time.Since(uploadStart).Seconds())
+		if errUpload != nil {
+			logger.Error("webdav upload failed", "event", "upload_failed", "dest", r.WebDAVPath, "error", errUpload)
+			recordOperation(cfg, HistoryEntry{Name: name, Rule: r.Name, Action: "upload", Source: dst, Dest: r.WebDAVPath, Size: size, Error: errUpload.Error()})
+			result.Status, result.Err = resultFailed, fmt.Errorf("webdav upload: %w", errUpload)
 		} else {
-			log.Printf("webdav uploaded: %s -> %s", filepath.Base(target), r.WebDAVPath)
+			logger.Info("webdav uploaded", "event", "uploaded", "dest", r.WebDAVPath, "duration", time.Since(uploadStart))
+			events.publish(FileEvent{Type: eventUploaded, Path: dst, Rule: r.Name, Dest: r.WebDAVPath, Bytes: size})
 		}
 	}
+	return result
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s /path/to/config.yaml\n", name)
+	fmt.Fprintf(os.Stderr, "       %s run [-once] /path/to/config.yaml\n", name)
+	fmt.Fprintf(os.Stderr, "       %s organize <dir> -config /path/to/config.yaml [-recursive] [-dry-run]\n", name)
+	fmt.Fprintf(os.Stderr, "       %s history [-config file] [-name glob] [-rule name] [-action action] [-since when] [-until when] [-failed] [-json]\n", name)
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s /path/to/config.yaml\n", filepath.Base(os.Args[0]))
+		usage()
 		os.Exit(2)
 	}
-	cfgPath := os.Args[1]
+	switch os.Args[1] {
+	case "history":
+		os.Exit(runHistory(os.Args[2:]))
+	case "run":
+		os.Exit(runCommand(os.Args[2:]))
+	case "organize":
+		os.Exit(runOrganize(os.Args[2:]))
+	case "-h", "-help", "--help":
+		usage()
+		os.Exit(0)
+	}
+	runDaemon(os.Args[1])
+}
+
+// runDaemon watches the configured directory and files everything that
+// appears in it until the process is killed.
+func runDaemon(cfgPath string) {
 	cfg, err := loadConfig(cfgPath)
 	if err != nil {
-		log.Fatalf("config error: %v", err)
+		fatal("config error", "event", "config_error", "error", err)
 	}
+	setupLogging(cfg)
 
 	watch := cfg.WatchDir
 	if fi, errStat := os.Stat(watch); errStat != nil || !fi.IsDir() {
-		log.Fatalf("watch_dir is not a directory: %s", watch)
+		fatal("watch_dir is not a directory", "event", "startup_failed", "dest", watch)
 	}
 
-	log.Printf("watching: %s", watch)
+	slog.Info("watching", "event", "watching", "dest", watch)
 
-	var dav *gowebdav.Client
-	if cfg.WebDAV.URL != "" {
-		dav = davClient(cfg.WebDAV)
-	}
+	d := newDaemon(cfgPath, cfg)
 
-	// Eagerly process existing files (optional; common quality-of-life)
-	entries, _ := os.ReadDir(watch)
-	for _, e := range entries {
-		if !e.IsDir() {
-			handleFile(filepath.Join(watch, e.Name()), cfg, dav, true)
-		}
+	if cfg.MetricsListen != "" {
+		go serveMetrics(cfg.MetricsListen)
 	}
+	go d.retryHeld(time.Duration(cfg.HoldRetrySec) * time.Second)
+	if cfg.RescanSec > 0 {
+		go d.reconcileEvery(time.Duration(cfg.RescanSec) * time.Second)
+	}
+	// Runs even with the digest off, so turning it on with a reload works
+	go runEmailDigest(func() Config {
+		c, _ := d.config()
+		return c
+	})
+
+	// Clean up after a crash before the scan files anything again
+	openJournal(cfg.JournalFile)
 
-	watcher, err := fsnotify.NewWatcher()
+	// Watch before scanning, so files arriving during the scan aren't missed
+	watcher, backend, err := newDirWatcher(cfg)
 	if err != nil {
-		log.Fatal(err)
+		fatal("watcher setup failed", "event", "startup_failed", "error", err)
 	}
 	defer func() { _ = watcher.Close() }()
 
 	if err := watcher.Add(watch); err != nil {
-		log.Fatal(err)
+		fatal("watch failed", "event", "startup_failed", "dest", watch, "error", err)
+	}
+	slog.Debug("watch backend", "event", "watch_backend", "dest", watch, "backend", backend)
+	d.mu.Lock()
+	d.watcher = watcher
+	d.mu.Unlock()
+
+	if cfg.APIListen != "" {
+		go serveAPI(cfg.APIListen, d)
 	}
 
+	// File what was already there (common quality-of-life) while handling events
+	go d.initialScan()
+
 	for {
 		select {
-		case ev := <-watcher.Events:
-			// We act on Create & Rename; Write can be noisy during downloads
+		case ev := <-watcher.Events():
+			// Create & Rename start a file; every change restarts its settle window
 			if ev.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
-				go handleFile(ev.Name, cfg, dav, false)
+				d.dispatch(ev.Name, false)
+			}
+			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) != 0 {
+				touchFile(ev.Name)
+				d.wakeDeferred(ev.Name)
+			}
+			if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				deferred.Delete(ev.Name)
+			}
+		case err := <-watcher.Errors():
+			slog.Error("watch error", "event", "watch_error", "error", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were lost, so any file may have been missed
+				go func() { _, _ = d.rescan() }()
 			}
-		case err := <-watcher.Errors:
-			log.Printf("watch error: %v", err)
 		}
 	}
 }
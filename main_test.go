@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -266,6 +268,10 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("PollMillis = %d, want 250", cfg.PollMillis)
 	}
 
+	if cfg.MaxWaitSec != 300 {
+		t.Errorf("MaxWaitSec = %d, want 300", cfg.MaxWaitSec)
+	}
+
 	if !cfg.CreateDestDirs {
 		t.Error("CreateDestDirs = false, want true")
 	}
@@ -329,6 +335,76 @@ func TestNotifyUser(t *testing.T) {
 	}
 }
 
+// Test moveByCopy copies, verifies and removes the source
+func TestMoveByCopy(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verify=%v", verify), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src", "a.bin")
+			dst := filepath.Join(dir, "nas", "sub", "a.bin")
+			data := bytes.Repeat([]byte("downwatch"), 100_000)
+			if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(src, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := moveByCopy(src, dst, copyOptions{verify: verify}); err != nil {
+				t.Fatalf("moveByCopy: %v", err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("destination content wrong (err %v)", err)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("source still exists: %v", err)
+			}
+			if _, err := os.Stat(tempPath(dst)); !os.IsNotExist(err) {
+				t.Errorf("temp file left behind: %v", err)
+			}
+		})
+	}
+}
+
+// Test verifyCopy rejects a copy whose hash differs
+func TestVerifyCopy(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "copy")
+	if err := os.WriteFile(p, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := hashFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCopy(p, want); err != nil {
+		t.Errorf("verifyCopy on identical content: %v", err)
+	}
+	if err := os.WriteFile(p, []byte("hellO"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCopy(p, want); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("verifyCopy on changed content = %v, want checksum mismatch", err)
+	}
+}
+
+// Test rule verify_moves overrides the global setting
+func TestCopyOptions(t *testing.T) {
+	off, on := false, true
+	cfg := Config{VerifyMoves: true}
+	if !cfg.copyOptions(&Rule{}).verify {
+		t.Error("rule without override should inherit verify_moves")
+	}
+	if cfg.copyOptions(&Rule{VerifyMoves: &off}).verify {
+		t.Error("rule verify_moves: false should disable verification")
+	}
+	cfg.VerifyMoves = false
+	if !cfg.copyOptions(&Rule{VerifyMoves: &on}).verify {
+		t.Error("rule verify_moves: true should enable verification")
+	}
+}
+
 // Benchmark rule matching
 func BenchmarkChooseRule(b *testing.B) {
 	tmpDir := b.TempDir()
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+)
+
+// fileMeta reads a file's metadata on first use, so rule matchers and dest
+// placeholders only cost what they use.
+type fileMeta struct {
+	path   string
+	origin func() downloadOrigin
+	exif   func() *exifData // nil if the file has none
+}
+
+func newFileMeta(p string) *fileMeta {
+	return &fileMeta{
+		path:   p,
+		origin: sync.OnceValue(func() downloadOrigin { return readOrigin(p) }),
+		exif: sync.OnceValue(func() *exifData {
+			x, err := readEXIF(p)
+			if err != nil {
+				return nil
+			}
+			return x
+		}),
+	}
+}
+
+// hasMetaMatchers reports whether r matches on file metadata.
+func (r *Rule) hasMetaMatchers() bool {
+	return r.hasOriginMatchers() || r.hasEXIFMatchers()
+}
+
+// metaMatches reports whether the file matches each kind of metadata matcher
+// r sets (download origin, EXIF). A rule without any matches every file.
+func (r *Rule) metaMatches(m *fileMeta) bool {
+	if r.hasOriginMatchers() && !r.originMatches(m.origin()) {
+		return false
+	}
+	return !r.hasEXIFMatchers() || r.exifMatches(m.exif())
+}
+
+// validateMetaMatchers checks the globs in r's origin and camera matchers.
+func validateMetaMatchers(r Rule) error {
+	for _, g := range slices.Concat(r.OriginHosts, r.ReferrerHosts, r.CameraMakes, r.CameraModels) {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", g, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are always collected; metrics_listen only controls whether they are served.
+var (
+	metricFilesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "downwatch",
+		Name:      "files_processed_total",
+		Help:      "Files handled successfully, by rule and action (move, copy, delete, skip).",
+	}, []string{"rule", "action"})
+
+	metricFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "downwatch",
+		Name:      "failures_total",
+		Help:      "Processing failures by stage (stability, mkdir, move, copy, delete, upload).",
+	}, []string{"stage"})
+
+	metricBytesMoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "downwatch",
+		Name:      "bytes_moved_total",
+		Help:      "Bytes filed to destination directories, by action (move, copy).",
+	}, []string{"action"})
+
+	metricStabilityWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "downwatch",
+		Name:      "stability_wait_seconds",
+		Help:      "Time spent waiting for a file to stop changing before filing it.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	metricUploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "downwatch",
+		Name:      "webdav_upload_duration_seconds",
+		Help:      "WebDAV upload latency, including failed and timed-out uploads.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+
+	metricQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "downwatch",
+		Name:      "queue_depth",
+		Help:      "Files waiting for their size to settle before they can be filed.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "downwatch",
+		Name:      "in_flight_files",
+		Help:      "Files currently being handled (entries in the processing map).",
+	}, func() float64 {
+		n := 0
+		processing.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		return float64(n)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "downwatch",
+		Name:      "held_files",
+		Help:      "Files held in the watch directory until their destination has enough free space.",
+	}, func() float64 {
+		return float64(heldFiles())
+	})
+)
+
+// observeOperation updates the counters for one recorded operation.
+func observeOperation(e HistoryEntry) {
+	if e.Error != "" {
+		metricFailures.WithLabelValues(e.Action).Inc()
+		return
+	}
+	metricFilesProcessed.WithLabelValues(e.Rule, e.Action).Inc()
+	if e.Action == "move" || e.Action == "copy" {
+		metricBytesMoved.WithLabelValues(e.Action).Add(float64(e.Size))
+	}
+}
+
+// serveMetrics exposes /metrics on addr until the listener fails.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving metrics", "event", "metrics_listening", "dest", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server failed", "event", "metrics_failed", "dest", addr, "error", err)
+	}
+}
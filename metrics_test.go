@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Test observeOperation counts successes, failures and bytes
+func TestObserveOperation(t *testing.T) {
+	processed := metricFilesProcessed.WithLabelValues("MetricsTest", "move")
+	failed := metricFailures.WithLabelValues("copy")
+	moved := metricBytesMoved.WithLabelValues("move")
+
+	beforeProcessed := testutil.ToFloat64(processed)
+	beforeFailed := testutil.ToFloat64(failed)
+	beforeBytes := testutil.ToFloat64(moved)
+
+	observeOperation(HistoryEntry{Rule: "MetricsTest", Action: "move", Size: 100})
+	observeOperation(HistoryEntry{Rule: "MetricsTest", Action: "copy", Size: 50, Error: "disk full"})
+
+	if got := testutil.ToFloat64(processed) - beforeProcessed; got != 1 {
+		t.Errorf("files_processed_total delta = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(failed) - beforeFailed; got != 1 {
+		t.Errorf("failures_total{stage=copy} delta = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(moved) - beforeBytes; got != 100 {
+		t.Errorf("bytes_moved_total delta = %v, want 100", got)
+	}
+}
+
+// Test the exposition includes the in-flight gauge backed by the processing map
+func TestMetricsExposition(t *testing.T) {
+	processing.Store("/tmp/metrics-test-a", newFileState())
+	processing.Store("/tmp/metrics-test-b", newFileState())
+	defer processing.Delete("/tmp/metrics-test-a")
+	defer processing.Delete("/tmp/metrics-test-b")
+
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"downwatch_in_flight_files 2",
+		"downwatch_queue_depth",
+		"# TYPE downwatch_stability_wait_seconds histogram",
+		"# TYPE downwatch_webdav_upload_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
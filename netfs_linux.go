@@ -0,0 +1,26 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// Filesystem magic numbers (statfs f_type) that inotify can't fully watch
+var networkFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse", // sshfs, rclone, ...
+	0x564c:     "ncp",
+	0x5346414f: "afs",
+	0x01021997: "9p", // WSL drives, VM shared folders
+	0x00c36400: "ceph",
+}
+
+// networkFS reports whether dir is on a network or FUSE filesystem, and which.
+func networkFS(dir string) (string, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return "", false
+	}
+	name, ok := networkFSTypes[uint32(st.Type)]
+	return name, ok
+}
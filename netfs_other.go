@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// networkFS isn't detected here, so the auto backend always uses fsnotify.
+func networkFS(string) (string, bool) { return "", false }
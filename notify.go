@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// notification is a desktop notification, optionally with action buttons.
+type notification struct {
+	Title   string
+	Message string
+	Actions []notifyAction // ignored by backends without button support
+}
+
+type notifyAction struct {
+	Label string
+	Run   func()
+}
+
+// notifier delivers desktop notifications.
+type notifier interface {
+	Notify(n notification) error
+}
+
+var (
+	desktopOnce sync.Once
+	desktop     notifier
+)
+
+// desktopNotifier returns the notifier for this platform, set up on first use:
+// osascript on macOS, org.freedesktop.Notifications over the session D-Bus on
+// Linux and the BSDs, nothing elsewhere or when no session bus is reachable.
+func desktopNotifier() notifier {
+	desktopOnce.Do(func() {
+		switch runtime.GOOS {
+		case "darwin":
+			desktop = osascriptNotifier{}
+		case "linux", "freebsd", "openbsd", "netbsd":
+			conn, err := dbus.ConnectSessionBus()
+			if err != nil {
+				slog.Warn("desktop notifications unavailable", "event", "notify_failed", "error", err)
+				desktop = noopNotifier{}
+				return
+			}
+			desktop = newDBusNotifier(conn)
+		default:
+			desktop = noopNotifier{}
+		}
+	})
+	return desktop
+}
+
+// sendNotification delivers n asynchronously to avoid blocking file processing.
+func sendNotification(n notification) {
+	deliveries.Go(func() {
+		if err := desktopNotifier().Notify(n); err != nil {
+			slog.Warn("notification failed", "event", "notify_failed", "error", err)
+		}
+	})
+}
+
+// notifyUser shows a plain desktop notification.
+func notifyUser(title, message string) {
+	sendNotification(notification{Title: title, Message: message})
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(notification) error { return nil }
+
+// osascriptNotifier sends a macOS native notification using osascript.
+// AppleScript notifications have no buttons, so actions are dropped.
+type osascriptNotifier struct{}
+
+func (osascriptNotifier) Notify(n notification) error {
+	// Escape quotes in strings for AppleScript
+	title := strings.ReplaceAll(n.Title, `"`, `\"`)
+	message := strings.ReplaceAll(n.Message, `"`, `\"`)
+
+	script := fmt.Sprintf(`display notification %q with title %q`, message, title)
+	return exec.Command("osascript", "-e", script).Run()
+}
+
+const (
+	notificationsName  = "org.freedesktop.Notifications"
+	notificationsPath  = dbus.ObjectPath("/org/freedesktop/Notifications")
+	notificationsIface = "org.freedesktop.Notifications"
+)
+
+// dbusConn is the part of *dbus.Conn the notifier uses, so tests can supply a fake bus.
+type dbusConn interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	AddMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+}
+
+// dbusNotifier talks to the freedesktop notification server and runs the
+// matching action when the user clicks a button.
+type dbusNotifier struct {
+	obj dbus.BusObject
+
+	mu      sync.Mutex
+	pending map[uint32][]notifyAction // notification ID -> actions, until closed
+}
+
+func newDBusNotifier(conn dbusConn) *dbusNotifier {
+	n := &dbusNotifier{
+		obj:     conn.Object(notificationsName, notificationsPath),
+		pending: make(map[uint32][]notifyAction),
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	for _, member := range []string{"ActionInvoked", "NotificationClosed"} {
+		if err := conn.AddMatchSignal(
+			dbus.WithMatchInterface(notificationsIface),
+			dbus.WithMatchMember(member),
+			dbus.WithMatchObjectPath(notificationsPath),
+		); err != nil {
+			slog.Warn("notification actions unavailable", "event", "notify_failed", "error", err)
+		}
+	}
+	go n.handleSignals(signals)
+	return n
+}
+
+func (n *dbusNotifier) Notify(note notification) error {
+	// Actions are a flat list of key, label pairs; the key is the button index
+	actions := make([]string, 0, 2*len(note.Actions))
+	for i, a := range note.Actions {
+		actions = append(actions, fmt.Sprint(i), a.Label)
+	}
+
+	var id uint32
+	err := n.obj.Call(notificationsIface+".Notify", 0,
+		"downwatch",               // app_name
+		uint32(0),                 // replaces_id
+		"",                        // app_icon
+		note.Title,                // summary
+		note.Message,              // body
+		actions,                   // actions
+		map[string]dbus.Variant{}, // hints
+		int32(-1),                 // expire_timeout: server default
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+	if len(note.Actions) > 0 {
+		n.mu.Lock()
+		n.pending[id] = note.Actions
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+func (n *dbusNotifier) handleSignals(signals <-chan *dbus.Signal) {
+	for sig := range signals {
+		if len(sig.Body) < 2 {
+			continue
+		}
+		id, ok := sig.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+		switch sig.Name {
+		case notificationsIface + ".ActionInvoked":
+			key, _ := sig.Body[1].(string)
+			n.invoke(id, key)
+		case notificationsIface + ".NotificationClosed":
+			n.mu.Lock()
+			delete(n.pending, id)
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *dbusNotifier) invoke(id uint32, key string) {
+	n.mu.Lock()
+	actions := n.pending[id]
+	delete(n.pending, id)
+	n.mu.Unlock()
+
+	for i, a := range actions {
+		if fmt.Sprint(i) == key {
+			a.Run()
+			return
+		}
+	}
+}
+
+// openFolder shows dir in the platform file manager.
+func openFolder(dir string) {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	if err := exec.Command(opener, dir).Start(); err != nil {
+		slog.Warn("open folder failed", "event", "open_failed", "dest", dir, "error", err)
+	}
+}
+
+// Files put back in the watch dir by undo (path -> os.FileInfo when restored).
+// handleFile leaves them alone until they change.
+var undone sync.Map
+
+func isUndone(path string, st os.FileInfo) bool {
+	v, ok := undone.Load(path)
+	if !ok {
+		return false
+	}
+	prev := v.(os.FileInfo)
+	if prev.Size() == st.Size() && prev.ModTime().Equal(st.ModTime()) {
+		return true
+	}
+	undone.Delete(path)
+	return false
+}
+
+// undoFiling reverses a successful move or copy from a notification button:
+// a moved file goes back to src, with the same copy options as the move
+// that filed it; a copied file is removed from dst.
+func undoFiling(action, src, dst string, opts copyOptions) {
+	var err error
+	switch action {
+	case "move":
+		if _, errStat := os.Stat(src); errStat == nil {
+			err = fmt.Errorf("%s already exists", src)
+			break
+		}
+		fi, errStat := os.Stat(dst)
+		if errStat != nil {
+			err = errStat
+			break
+		}
+		// Mark before moving so the watcher doesn't file it straight back
+		undone.Store(src, fi)
+		if err = atomicMove(dst, src, opts); err != nil {
+			undone.Delete(src)
+		}
+	case "copy":
+		err = os.Remove(dst)
+	}
+	if err != nil {
+		slog.Error("undo failed", "event", "undo_failed", "file", dst, "action", action, "error", err)
+		return
+	}
+	slog.Info("undone", "event", "undone", "file", dst, "action", action, "dest", src)
+}
+
+// filingActions returns the buttons offered after a successful move or copy.
+// opts are the copy options the file was filed with.
+func filingActions(action, src, dst, destDir string, opts copyOptions) []notifyAction {
+	return []notifyAction{
+		{Label: "Open folder", Run: func() { openFolder(destDir) }},
+		{Label: "Undo", Run: func() { undoFiling(action, src, dst, opts) }},
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeBus stands in for the session bus: it records Notify calls and lets
+// the test emit signals as the notification server would.
+type fakeBus struct {
+	mu      sync.Mutex
+	calls   [][]any
+	matches int
+	signals chan<- *dbus.Signal
+	nextID  uint32
+}
+
+func (b *fakeBus) Object(string, dbus.ObjectPath) dbus.BusObject { return fakeObject{b} }
+
+func (b *fakeBus) AddMatchSignal(...dbus.MatchOption) error {
+	b.mu.Lock()
+	b.matches++
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *fakeBus) Signal(ch chan<- *dbus.Signal) { b.signals = ch }
+
+// fakeObject is the notification server object on the fake bus. The
+// notifier only uses Call; the rest of dbus.BusObject is stubbed out.
+type fakeObject struct {
+	bus *fakeBus
+}
+
+func (o fakeObject) Call(method string, flags dbus.Flags, args ...any) *dbus.Call {
+	return o.bus.call(method, flags, args...)
+}
+
+func (fakeObject) CallWithContext(context.Context, string, dbus.Flags, ...any) *dbus.Call {
+	panic("not implemented")
+}
+
+func (fakeObject) Go(string, dbus.Flags, chan *dbus.Call, ...any) *dbus.Call {
+	panic("not implemented")
+}
+
+func (fakeObject) GoWithContext(context.Context, string, dbus.Flags, chan *dbus.Call, ...any) *dbus.Call {
+	panic("not implemented")
+}
+
+func (fakeObject) AddMatchSignal(string, string, ...dbus.MatchOption) *dbus.Call {
+	panic("not implemented")
+}
+
+func (fakeObject) RemoveMatchSignal(string, string, ...dbus.MatchOption) *dbus.Call {
+	panic("not implemented")
+}
+
+func (fakeObject) GetProperty(string) (dbus.Variant, error) { panic("not implemented") }
+func (fakeObject) StoreProperty(string, any) error          { panic("not implemented") }
+func (fakeObject) SetProperty(string, any) error            { panic("not implemented") }
+func (fakeObject) Destination() string                      { return notificationsName }
+func (fakeObject) Path() dbus.ObjectPath                    { return notificationsPath }
+
+func (b *fakeBus) call(method string, _ dbus.Flags, args ...any) *dbus.Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if method != notificationsIface+".Notify" {
+		return &dbus.Call{Err: dbus.ErrMsgNoObject}
+	}
+	b.calls = append(b.calls, args)
+	b.nextID++
+	return &dbus.Call{Body: []any{b.nextID}}
+}
+
+func (b *fakeBus) emit(member string, body ...any) {
+	b.signals <- &dbus.Signal{Path: notificationsPath, Name: notificationsIface + "." + member, Body: body}
+}
+
+// Test Notify sends the freedesktop call with flattened actions
+func TestDBusNotifierNotify(t *testing.T) {
+	bus := &fakeBus{}
+	n := newDBusNotifier(bus)
+
+	err := n.Notify(notification{
+		Title:   "downwatch",
+		Message: "Moved a.pdf to /docs",
+		Actions: []notifyAction{{Label: "Open folder", Run: func() {}}, {Label: "Undo", Run: func() {}}},
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if bus.matches != 2 {
+		t.Errorf("AddMatchSignal called %d times, want 2", bus.matches)
+	}
+	if len(bus.calls) != 1 {
+		t.Fatalf("got %d Notify calls, want 1", len(bus.calls))
+	}
+	args := bus.calls[0]
+	if len(args) != 8 {
+		t.Fatalf("Notify args = %v, want 8 (susssasa{sv}i)", args)
+	}
+	if args[0] != "downwatch" || args[3] != "downwatch" || args[4] != "Moved a.pdf to /docs" {
+		t.Errorf("Notify app/summary/body = %v/%v/%v", args[0], args[3], args[4])
+	}
+	actions, _ := args[5].([]string)
+	want := []string{"0", "Open folder", "1", "Undo"}
+	if len(actions) != len(want) {
+		t.Fatalf("actions = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("actions = %v, want %v", actions, want)
+			break
+		}
+	}
+}
+
+// Test ActionInvoked runs the matching action once, and closed notifications forget theirs
+func TestDBusNotifierActions(t *testing.T) {
+	bus := &fakeBus{}
+	n := newDBusNotifier(bus)
+
+	ran := make(chan string, 4)
+	for _, title := range []string{"first", "second"} {
+		err := n.Notify(notification{Title: title, Actions: []notifyAction{
+			{Label: "Open folder", Run: func() { ran <- title + ":open" }},
+			{Label: "Undo", Run: func() { ran <- title + ":undo" }},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bus.emit("NotificationClosed", uint32(1), uint32(2))
+	bus.emit("ActionInvoked", uint32(1), "1") // closed; ignored
+	bus.emit("ActionInvoked", uint32(2), "1")
+	bus.emit("ActionInvoked", uint32(2), "0") // already handled; ignored
+
+	select {
+	case got := <-ran:
+		if got != "second:undo" {
+			t.Errorf("ran %q, want second:undo", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("action not run")
+	}
+	select {
+	case got := <-ran:
+		t.Errorf("unexpected extra action %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test undo puts a moved file back and keeps it from being filed again
+func TestUndoFilingMove(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "watch", "a.pdf")
+	dst := filepath.Join(dir, "docs", "a.pdf")
+	for _, d := range []string{filepath.Dir(src), filepath.Dir(dst)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(dst, []byte("%PDF-1.4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	undoFiling("move", src, dst, copyOptions{})
+	defer undone.Delete(src)
+
+	st, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("source not restored: %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination still exists after undo: %v", err)
+	}
+	if !isUndone(src, st) {
+		t.Error("restored file not marked as undone")
+	}
+
+	// A changed file at the same path is processed normally again
+	if err := os.WriteFile(src, []byte("%PDF-1.7 new download"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, _ = os.Stat(src)
+	if isUndone(src, st) {
+		t.Error("changed file still treated as undone")
+	}
+}
+
+// Test undo of a copy removes only the copy
+func TestUndoFilingCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.pdf")
+	dst := filepath.Join(dir, "copy.pdf")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	undoFiling("copy", src, dst, copyOptions{})
+
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("copy still exists after undo: %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("original removed by undo: %v", err)
+	}
+}
+
+// Test undo uses the filing rule's copy options, not the defaults
+func TestUndoOptions(t *testing.T) {
+	verify := true
+	cfg := defaultConfig()
+	cfg.Preserve = PreserveConfig{Mode: true, Xattrs: false}
+	cfg.Rules = []Rule{{Name: "Photos", VerifyMoves: &verify}}
+
+	got := cfg.undoOptions(HistoryEntry{Rule: "Photos", Action: "move"})
+	if !got.verify || got.preserve != cfg.Preserve {
+		t.Errorf("undoOptions() = %+v, want verify and the configured preserve settings", got)
+	}
+	if got := cfg.undoOptions(HistoryEntry{Rule: "Gone", Action: "move"}); got.verify != cfg.VerifyMoves {
+		t.Errorf("undoOptions() for an unknown rule = %+v, want the global settings", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// runOrganize implements `downwatch organize <dir> -config cfg.yaml [-recursive] [-dry-run]`
+// and returns the exit code.
+func runOrganize(args []string) int {
+	fset := flag.NewFlagSet("organize", flag.ContinueOnError)
+	cfgPath := fset.String("config", "", "config file whose rules to apply (required)")
+	recursive := fset.Bool("recursive", false, "also organize files in subdirectories (hidden ones are skipped)")
+	dryRun := fset.Bool("dry-run", false, "report what would be done without touching any file")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: %s organize <dir> -config cfg.yaml [-recursive] [-dry-run]\n", filepath.Base(os.Args[0]))
+		fset.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fset, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 || *cfgPath == "" {
+		fset.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		return 1
+	}
+	setupLogging(cfg)
+	root, err := expandHome(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return organize(cfg, root, *recursive, *dryRun, os.Stdout)
+}
+
+// organize applies the rules to every file in root (and, if recursive, its
+// subdirectories), writes a report to w and returns the exit code: 0, or 1 if
+// any file failed.
+func organize(cfg Config, root string, recursive, dryRun bool, w io.Writer) int {
+	paths, err := listFiles(root, recursive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "organize: %v\n", err)
+		return 1
+	}
+	var dav *gowebdav.Client
+	if !dryRun {
+		openJournal(cfg.JournalFile)
+		if cfg.WebDAV.URL != "" {
+			dav = davClient(cfg.WebDAV)
+		}
+	}
+
+	// Existing files are complete, so there's nothing to wait for
+	opts := handleOptions{skipStability: true, dryRun: dryRun}
+	results := make([]fileResult, len(paths))
+	for i, p := range paths {
+		results[i] = handleFile(p, cfg, dav, opts)
+	}
+	if !dryRun {
+		notifyDigest.flush()
+		waitDeliveries(deliveryTimeout)
+	}
+
+	if errPrint := printOrganizeReport(w, root, paths, results, dryRun); errPrint != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", errPrint)
+	}
+	for _, r := range results {
+		if r.Status == resultFailed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// listFiles returns the regular files in root, and with recursive those in
+// its subdirectories too, skipping hidden directories such as .git. The list
+// is complete before anything is moved, so files filed into a destination
+// inside root aren't visited twice.
+func listFiles(root string, recursive bool) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == root {
+				return nil
+			}
+			if !recursive || strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// organizeVerb describes a result for the report, e.g. "moved" or "would copy".
+func organizeVerb(r fileResult, dryRun bool) string {
+	var done, planned string
+	switch r.Status {
+	case resultFiled:
+		done, planned = "moved", "would move"
+		if r.Action == "copy" {
+			done, planned = "copied", "would copy"
+		}
+	case resultDuplicate:
+		done, planned = "deleted duplicate", "would delete duplicate"
+		if r.Action == "skip" {
+			done, planned = "skipped duplicate", "would skip duplicate"
+		}
+	case resultHeld:
+		done, planned = "held (no space)", "would hold (no space)"
+	default:
+		return r.Status
+	}
+	if dryRun {
+		return planned
+	}
+	return done
+}
+
+// printOrganizeReport lists every file with what happened to it, followed by
+// a count per outcome.
+func printOrganizeReport(w io.Writer, root string, paths []string, results []fileResult, dryRun bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var order []string
+	counts := make(map[string]int)
+	for i, r := range results {
+		verb := organizeVerb(r, dryRun)
+		if counts[verb] == 0 {
+			order = append(order, verb)
+		}
+		counts[verb]++
+
+		name, errRel := filepath.Rel(root, paths[i])
+		if errRel != nil {
+			name = paths[i]
+		}
+		detail := r.Dest
+		switch {
+		case r.Err != nil:
+			detail = r.Err.Error()
+		case r.Status == resultSkipped:
+			detail = "ignored"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", verb, name, r.Rule, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	noun := "files"
+	if len(results) == 1 {
+		noun = "file"
+	}
+	parts := []string{fmt.Sprintf("%d %s", len(results), noun)}
+	for _, verb := range order {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[verb], verb))
+	}
+	summary := strings.Join(parts, ", ")
+	if dryRun {
+		summary = "dry run: " + summary
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
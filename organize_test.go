@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// organizeTree creates a messy tree and a config whose rules file into it
+func organizeTree(t *testing.T) (root string, cfg Config) {
+	t.Helper()
+	root = t.TempDir()
+	for name, content := range map[string]string{
+		"report.pdf":              "%PDF-1.4",
+		"photo.jpg":               "jpeg",
+		"notes.xyz":               "?",
+		"old/invoice.pdf":         "%PDF-1.7",
+		"old/deeper/scan.pdf":     "%PDF-1.5",
+		".git/objects/pack.pdf":   "not really",
+		"Documents/PDFs/done.pdf": "%PDF-1.3",
+	} {
+		writeFile(t, filepath.Join(root, name), content)
+	}
+	cfg = defaultConfig()
+	cfg.HistoryFile = ""
+	cfg.JournalFile = ""
+	cfg.Notifications = false
+	cfg.Rules = []Rule{
+		{Name: "PDFs", Extensions: []string{"pdf"}, Action: "move", Dest: filepath.Join(root, "Documents", "PDFs")},
+		{Name: "Photos", Extensions: []string{"jpg"}, Action: "copy", Dest: filepath.Join(root, "Pictures")},
+	}
+	return root, cfg
+}
+
+// Test a dry run reports the plan and touches nothing
+func TestOrganizeDryRun(t *testing.T) {
+	root, cfg := organizeTree(t)
+
+	var out bytes.Buffer
+	if code := organize(cfg, root, true, true, &out); code != 0 {
+		t.Fatalf("organize() = %d, want 0\n%s", code, out.String())
+	}
+	got := out.String()
+	for _, want := range []string{
+		"would move  old/deeper/scan.pdf",
+		"would copy  photo.jpg",
+		"unmatched   notes.xyz",
+		"dry run: 6 files",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "pack.pdf") {
+		t.Errorf("hidden directory was walked:\n%s", got)
+	}
+	if !exists(filepath.Join(root, "report.pdf")) || exists(filepath.Join(root, "Pictures")) {
+		t.Error("dry run changed files")
+	}
+}
+
+// Test organize files the tree, skipping files already in place
+func TestOrganizeRecursive(t *testing.T) {
+	root, cfg := organizeTree(t)
+
+	var out bytes.Buffer
+	if code := organize(cfg, root, true, false, &out); code != 0 {
+		t.Fatalf("organize() = %d, want 0\n%s", code, out.String())
+	}
+	pdfs := filepath.Join(root, "Documents", "PDFs")
+	for _, p := range []string{
+		filepath.Join(pdfs, "report.pdf"),
+		filepath.Join(pdfs, "invoice.pdf"),
+		filepath.Join(pdfs, "scan.pdf"),
+		filepath.Join(pdfs, "done.pdf"),
+		filepath.Join(root, "Pictures", "photo.jpg"),
+		filepath.Join(root, "photo.jpg"),
+		filepath.Join(root, ".git", "objects", "pack.pdf"),
+	} {
+		if !exists(p) {
+			t.Errorf("%s missing", p)
+		}
+	}
+	if exists(filepath.Join(pdfs, "done (2).pdf")) {
+		t.Error("file already in its destination was moved again")
+	}
+	got := out.String()
+	if !strings.Contains(got, "6 files, ") || !strings.Contains(got, "3 moved") || !strings.Contains(got, "1 copied") ||
+		!strings.Contains(got, "1 unmatched") || !strings.Contains(got, "1 skipped") {
+		t.Errorf("unexpected summary:\n%s", got)
+	}
+	if !strings.Contains(got, "already in its destination") {
+		t.Errorf("skip reason not reported:\n%s", got)
+	}
+}
+
+// Test without -recursive only the top level is organized
+func TestOrganizeTopLevel(t *testing.T) {
+	root, cfg := organizeTree(t)
+
+	var out bytes.Buffer
+	if code := organize(cfg, root, false, false, &out); code != 0 {
+		t.Fatalf("organize() = %d, want 0\n%s", code, out.String())
+	}
+	if !exists(filepath.Join(root, "old", "invoice.pdf")) {
+		t.Error("file in subdirectory organized without -recursive")
+	}
+	if !strings.Contains(out.String(), "3 files, ") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+// Test organize argument validation
+func TestRunOrganizeArgs(t *testing.T) {
+	for _, args := range [][]string{{}, {"dir"}, {"-config", "cfg.yaml"}, {"a", "b", "-config", "cfg.yaml"}} {
+		if got := runOrganize(args); got != 2 {
+			t.Errorf("runOrganize(%v) = %d, want 2", args, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Extended attributes in which browsers on Linux (Chrome, Firefox) and
+// tools such as wget and curl --xattr record where a file came from
+const (
+	xattrOrigin   = "user.xdg.origin.url"
+	xattrReferrer = "user.xdg.referrer.url"
+)
+
+// downloadOrigin is where a file was downloaded from. Fields are empty when
+// the attribute is missing or unreadable.
+type downloadOrigin struct {
+	url      string
+	referrer string
+}
+
+func readOrigin(p string) downloadOrigin {
+	var o downloadOrigin
+	if v, err := getXattr(p, xattrOrigin); err == nil {
+		o.url = strings.TrimSpace(string(v))
+	}
+	if v, err := getXattr(p, xattrReferrer); err == nil {
+		o.referrer = strings.TrimSpace(string(v))
+	}
+	return o
+}
+
+// hostOf returns the lowercased host name of a URL, or "" if there is none.
+func hostOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hasOriginMatchers reports whether r matches on download origin.
+func (r *Rule) hasOriginMatchers() bool {
+	return len(r.OriginHosts) > 0 || len(r.OriginURLs) > 0 || len(r.ReferrerHosts) > 0 || len(r.ReferrerURLs) > 0
+}
+
+// originMatches reports whether o matches any of r's origin matchers. A file
+// without origin attributes matches none of them.
+func (r *Rule) originMatches(o downloadOrigin) bool {
+	return hostMatches(hostOf(o.url), r.OriginHosts) || urlMatches(o.url, r.OriginURLs) ||
+		hostMatches(hostOf(o.referrer), r.ReferrerHosts) || urlMatches(o.referrer, r.ReferrerURLs)
+}
+
+// hostMatches matches host against globs such as "jira.example.com" or
+// "*.atlassian.net", case-insensitively.
+func hostMatches(host string, patterns []string) bool {
+	return foldMatches(host, patterns)
+}
+
+// urlMatches matches u against patterns in which "*" stands for any run of
+// characters, slashes included, e.g. "https://jira.example.com/secure/attachment/*".
+func urlMatches(u string, patterns []string) bool {
+	if u == "" {
+		return false
+	}
+	for _, p := range patterns {
+		if wildcardMatch(p, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch reports whether s matches pattern, where "*" matches any
+// (possibly empty) string and everything else matches itself.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
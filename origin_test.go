@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// Test wildcardMatch, where * spans any characters including slashes
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"https://jira.example.com/secure/attachment/*", "https://jira.example.com/secure/attachment/10001/spec.pdf", true},
+		{"https://jira.example.com/secure/attachment/*", "https://jira.example.com/browse/PROJ-1", false},
+		{"*://*.example.com/*", "https://cdn.example.com/a/b.zip", true},
+		{"*://*.example.com/*", "https://example.org/a", false},
+		{"https://example.com/", "https://example.com/", true},
+		{"https://example.com/", "https://example.com/x", false},
+		{"*.pdf", "https://x/a.pdf", true},
+		{"a*a", "a", false},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// Test originMatches on hosts and URLs, and that a file without origin matches nothing
+func TestOriginMatches(t *testing.T) {
+	jira := &Rule{Name: "Jira", OriginHosts: []string{"jira.example.com", "*.atlassian.net"}}
+	attachments := &Rule{Name: "Attachments", OriginURLs: []string{"https://files.example.com/attachment/*"}}
+	fromWiki := &Rule{Name: "Wiki", ReferrerHosts: []string{"wiki.example.com"}}
+	catchAll := &Rule{Name: "Any", OriginHosts: []string{"*"}}
+
+	tests := []struct {
+		name   string
+		rule   *Rule
+		origin downloadOrigin
+		want   bool
+	}{
+		{"host", jira, downloadOrigin{url: "https://JIRA.example.com/secure/attachment/1/a.pdf"}, true},
+		{"host glob", jira, downloadOrigin{url: "https://acme.atlassian.net/rest/api/file"}, true},
+		{"other host", jira, downloadOrigin{url: "https://example.com/a.pdf"}, false},
+		{"url pattern", attachments, downloadOrigin{url: "https://files.example.com/attachment/7/report.xlsx"}, true},
+		{"referrer host", fromWiki, downloadOrigin{url: "https://cdn.example.net/x.zip", referrer: "https://wiki.example.com/page"}, true},
+		{"referrer only checked for referrer matchers", jira, downloadOrigin{url: "https://cdn.example.net/x.zip", referrer: "https://jira.example.com/"}, false},
+		{"no origin", catchAll, downloadOrigin{}, false},
+		{"unparsable", catchAll, downloadOrigin{url: "::not a url"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.originMatches(tt.origin); got != tt.want {
+				t.Errorf("originMatches(%+v) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Test a browser-recorded origin picks the rule and fills in the dest template
+func TestHandleFileByOrigin(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	work := filepath.Join(dir, "Work")
+	cfg.Rules = append([]Rule{{Name: "Jira", OriginHosts: []string{"jira.example.com"}, Action: "move", Dest: filepath.Join(work, "{origin.host}")}}, cfg.Rules...)
+
+	spec := filepath.Join(cfg.WatchDir, "spec.pdf")
+	other := filepath.Join(cfg.WatchDir, "other.pdf")
+	writeFile(t, spec, "%PDF-1.4")
+	writeFile(t, other, "%PDF-1.4")
+	if err := unix.Setxattr(spec, xattrOrigin, []byte("https://jira.example.com/secure/attachment/1/spec.pdf"), 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+			t.Skip("no user xattrs on this filesystem")
+		}
+		t.Fatal(err)
+	}
+
+	if res := handleFile(spec, cfg, nil, handleOptions{skipStability: true}); res.Rule != "Jira" || res.Status != resultFiled {
+		t.Errorf("spec.pdf: %+v, want filed by Jira", res)
+	}
+	if _, err := os.Stat(filepath.Join(work, "jira.example.com", "spec.pdf")); err != nil {
+		t.Errorf("spec.pdf not in the origin host's folder: %v", err)
+	}
+	// No xattr: no match, falls through to the extension rule
+	if res := handleFile(other, cfg, nil, handleOptions{skipStability: true}); res.Rule != "PDFs" {
+		t.Errorf("other.pdf: %+v, want the PDFs rule", res)
+	}
+}
+
+// Test a rule with both extensions and origin_hosts needs both to match
+func TestChooseRuleOriginAndExtension(t *testing.T) {
+	dir := t.TempDir()
+	rules := []Rule{{Name: "Jira zips", Extensions: []string{"zip"}, OriginHosts: []string{"jira.example.com"}}}
+	files := map[string]bool{
+		"logs.zip":  true,  // zip from Jira
+		"spec.pdf":  false, // from Jira, but not a zip
+		"other.zip": false, // a zip, but no origin
+	}
+	for name := range files {
+		p := filepath.Join(dir, name)
+		writeFile(t, p, "data")
+		if name == "other.zip" {
+			continue
+		}
+		if err := unix.Setxattr(p, xattrOrigin, []byte("https://jira.example.com/secure/attachment/1/"+name), 0); err != nil {
+			if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+				t.Skip("no user xattrs on this filesystem")
+			}
+			t.Fatal(err)
+		}
+	}
+	for name, want := range files {
+		if got := chooseRule(filepath.Join(dir, name), rules) != nil; got != want {
+			t.Errorf("%s matched = %v, want %v", name, got, want)
+		}
+	}
+}
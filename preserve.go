@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type PreserveConfig struct {
+	Mode   bool `yaml:"mode"`   // permission bits; default true
+	Times  bool `yaml:"times"`  // modification and access times; default true
+	Owner  bool `yaml:"owner"`  // user and group, when permitted (usually only as root); default true
+	Xattrs bool `yaml:"xattrs"` // extended attributes such as user.xdg.origin.url; default true
+}
+
+// preserveAttrs copies the attributes selected by p from src, as described by
+// st, to dst. st must be taken before src is read, or the access time it
+// carries is the copy's own read. Owner and xattr changes the destination
+// doesn't allow are skipped silently; other failures are joined into the
+// returned error. Times go last because changing xattrs or ownership can touch
+// them.
+func preserveAttrs(src string, st os.FileInfo, dst string, p PreserveConfig) error {
+	var errs []error
+	if p.Xattrs {
+		errs = append(errs, copyXattrs(src, dst))
+	}
+	if p.Owner {
+		errs = append(errs, copyOwner(dst, st))
+	}
+	if p.Mode {
+		// After chown, which clears setuid/setgid bits
+		errs = append(errs, os.Chmod(dst, st.Mode().Perm()))
+	}
+	if p.Times {
+		errs = append(errs, os.Chtimes(dst, accessTime(st), st.ModTime()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time
+// isn't portably available.
+func accessTime(st os.FileInfo) time.Time {
+	return st.ModTime()
+}
+
+func copyOwner(string, os.FileInfo) error { return nil }
+
+func copyXattrs(string, string) error { return nil }
+
+func getXattr(string, string) ([]byte, error) { return nil, errors.ErrUnsupported }
@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const originAttr = "user.xdg.origin.url"
+
+// Test copyTo keeps mode, times and xattrs, and drops them when disabled
+func TestCopyToPreserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		preserve PreserveConfig
+	}{
+		{"all", PreserveConfig{Mode: true, Times: true, Owner: true, Xattrs: true}},
+		{"none", PreserveConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "a.pdf")
+			dst := filepath.Join(dir, "out", "a.pdf")
+			if err := os.WriteFile(src, []byte("%PDF-1.4"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(src, 0o640); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			atime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(src, atime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			hasXattrs := true
+			if err := unix.Setxattr(src, originAttr, []byte("https://example.com/a.pdf"), 0); err != nil {
+				if !errors.Is(err, unix.ENOTSUP) && !errors.Is(err, unix.EOPNOTSUPP) {
+					t.Fatal(err)
+				}
+				hasXattrs = false
+			}
+
+			if err := copyTo(src, dst, copyOptions{preserve: tt.preserve}); err != nil {
+				t.Fatalf("copyTo: %v", err)
+			}
+			st, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keep := tt.preserve.Mode
+			if got := st.Mode().Perm() == 0o640; got != keep {
+				t.Errorf("mode = %v, preserve %v", st.Mode().Perm(), keep)
+			}
+			if got := st.ModTime().Equal(mtime); got != tt.preserve.Times {
+				t.Errorf("mtime = %v, preserve %v", st.ModTime(), tt.preserve.Times)
+			}
+			if tt.preserve.Times && !accessTime(st).Equal(atime) {
+				t.Errorf("atime = %v, want %v", accessTime(st), atime)
+			}
+			if !hasXattrs {
+				return
+			}
+			val, err := getXattr(dst, originAttr)
+			if tt.preserve.Xattrs && string(val) != "https://example.com/a.pdf" {
+				t.Errorf("xattr = %q (err %v), want origin url", val, err)
+			}
+			if !tt.preserve.Xattrs && err == nil {
+				t.Errorf("xattr copied although disabled: %q", val)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyOwner sets dst's user and group to those in st. Unprivileged users
+// usually can't, which isn't an error.
+func copyOwner(dst string, st os.FileInfo) error {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := os.Lchown(dst, int(sys.Uid), int(sys.Gid))
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// copyXattrs copies every extended attribute of src to dst. Attributes the
+// destination filesystem or our privileges don't allow (e.g. security.* on a
+// NAS mount) are skipped.
+func copyXattrs(src, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if xattrUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, name := range names {
+		val, errGet := getXattr(src, name)
+		if errGet != nil {
+			errs = append(errs, fmt.Errorf("xattr %s: %w", name, errGet))
+			continue
+		}
+		if errSet := unix.Setxattr(dst, name, val, 0); errSet != nil && !xattrUnsupported(errSet) {
+			errs = append(errs, fmt.Errorf("xattr %s: %w", name, errSet))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func xattrUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES)
+}
+
+// listXattrs returns the names of path's extended attributes.
+func listXattrs(path string) ([]string, error) {
+	buf, err := readXattrBuf(func(b []byte) (int, error) { return unix.Listxattr(path, b) })
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range bytes.SplitSeq(buf, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	return readXattrBuf(func(b []byte) (int, error) { return unix.Getxattr(path, name, b) })
+}
+
+// readXattrBuf calls read with a nil buffer to learn the size, then with a
+// buffer of that size, retrying if the attribute grew in between.
+func readXattrBuf(read func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n)
+		n, err = read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// How long a one-shot run waits for notifications, webhooks and emails to go out
+var deliveryTimeout = 30 * time.Second
+
+// runCommand implements `downwatch run [-once] config.yaml` and returns the exit code.
+// Without -once it runs the daemon, like `downwatch config.yaml`.
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	once := fs.Bool("once", false, "process the watch dir once, print a summary and exit (1 if anything failed)")
+	cfgFlag := fs.String("config", "", "config file (or give it as the argument)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s run [-once] /path/to/config.yaml\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	cfgPath := *cfgFlag
+	switch {
+	case cfgPath == "" && len(positional) == 1:
+		cfgPath = positional[0]
+	case cfgPath == "" || len(positional) > 0:
+		fs.Usage()
+		return 2
+	}
+
+	if !*once {
+		runDaemon(cfgPath)
+		return 0
+	}
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		return 1
+	}
+	setupLogging(cfg)
+	return runOnce(cfg, os.Stdout)
+}
+
+// parseInterspersed parses args with fs, allowing flags after positional
+// arguments (`run config.yaml --once`), and returns the positional ones.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// runWorkers is how many files a one-shot run handles at a time.
+const runWorkers = 8
+
+// runOnce files everything currently in the watch dir in initial_scan_order,
+// writes a summary to w and returns the exit code: 0, or 1 if any file failed
+// or never stabilized. As on the daemon's initial scan, only files modified
+// within initial_scan_old_seconds (or with a download in progress beside
+// them) wait to be stable.
+func runOnce(cfg Config, w io.Writer) int {
+	files, err := scanFiles(cfg.WatchDir, cfg.ScanOrder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watch_dir: %v\n", err)
+		return 1
+	}
+	openJournal(cfg.JournalFile)
+	var dav *gowebdav.Client
+	if cfg.WebDAV.URL != "" {
+		dav = davClient(cfg.WebDAV)
+	}
+
+	// Files settle in parallel, as they would under the daemon, but only
+	// runWorkers at a time
+	oldBefore := time.Now().Add(-time.Duration(cfg.ScanOldSec) * time.Second)
+	paths := make([]string, len(files))
+	results := make([]fileResult, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runWorkers, len(files)) {
+		wg.Go(func() {
+			for i := range next {
+				opts := handleOptions{skipStability: !cfg.needsWait(files[i], oldBefore)}
+				results[i] = handleFile(files[i].path, cfg, dav, opts)
+				// Left in the watch dir unfiled: cron should hear about it
+				if errors.Is(results[i].Err, errNotStable) {
+					results[i].Status = resultFailed
+				}
+			}
+		})
+	}
+	for i, f := range files {
+		paths[i] = f.path
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	notifyDigest.flush()
+	// There's no digest_at to wait for: report this run's operations now
+	if c := cfg.Email; c.Host != "" && c.Mode == "digest" {
+		c.SendEmpty = false
+		sendDigest(c, time.Now())
+	}
+	waitDeliveries(deliveryTimeout)
+
+	if errPrint := printRunSummary(w, paths, results); errPrint != nil {
+		fmt.Fprintf(os.Stderr, "summary: %v\n", errPrint)
+	}
+	for _, r := range results {
+		if r.Status == resultFailed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// waitDeliveries waits up to timeout for background deliveries to finish.
+func waitDeliveries(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("gave up waiting for notifications", "event", "deliveries_timeout", "duration", timeout)
+	}
+}
+
+// printRunSummary lists what happened to each file, leaving out ignored ones,
+// followed by a count per outcome.
+func printRunSummary(w io.Writer, paths []string, results []fileResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	counts := make(map[string]int)
+	for i, r := range results {
+		counts[r.Status]++
+		if r.Status == resultSkipped && r.Err == nil {
+			continue
+		}
+		detail := r.Dest
+		if r.Err != nil {
+			detail = r.Err.Error()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Status, filepath.Base(paths[i]), r.Rule, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	noun := "files"
+	if len(results) == 1 {
+		noun = "file"
+	}
+	parts := []string{fmt.Sprintf("%d %s", len(results), noun)}
+	for _, status := range []string{resultFiled, resultDuplicate, resultUnmatched, resultHeld, resultSkipped, resultFailed} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	_, err := fmt.Fprintln(w, strings.Join(parts, ", "))
+	return err
+}
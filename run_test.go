@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func onceConfig(t *testing.T, dir string) Config {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.WatchDir = filepath.Join(dir, "watch")
+	cfg.SettleMillis = 50
+	cfg.PollMillis = 10
+	cfg.HistoryFile = ""
+	cfg.JournalFile = ""
+	cfg.Notifications = false
+	cfg.Rules = []Rule{{Name: "PDFs", Extensions: []string{"pdf"}, Action: "move", Dest: filepath.Join(dir, "docs")}}
+	return cfg
+}
+
+// Test runOnce files everything, prints a summary and exits 0
+func TestRunOnce(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	writeFile(t, filepath.Join(cfg.WatchDir, "a.pdf"), "%PDF-1.4")
+	writeFile(t, filepath.Join(cfg.WatchDir, "b.pdf"), "%PDF-1.7")
+	writeFile(t, filepath.Join(cfg.WatchDir, "notes.xyz"), "?")
+	writeFile(t, filepath.Join(cfg.WatchDir, "movie.part"), "partial")
+
+	var out bytes.Buffer
+	if code := runOnce(cfg, &out); code != 0 {
+		t.Fatalf("runOnce() = %d, want 0\n%s", code, out.String())
+	}
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		if !exists(filepath.Join(dir, "docs", name)) {
+			t.Errorf("%s not filed", name)
+		}
+	}
+	got := out.String()
+	if !strings.Contains(got, "4 files, 2 filed, 1 unmatched, 1 skipped") {
+		t.Errorf("summary line missing:\n%s", got)
+	}
+	if strings.Contains(got, "movie.part") {
+		t.Errorf("ignored file listed:\n%s", got)
+	}
+	if !strings.Contains(got, "unmatched  notes.xyz") {
+		t.Errorf("unmatched file not listed:\n%s", got)
+	}
+}
+
+// Test runOnce exits 1 when a file fails
+func TestRunOnceFailure(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	writeFile(t, filepath.Join(cfg.WatchDir, "a.pdf"), "%PDF-1.4")
+	// dest can't be created: a file is in the way
+	writeFile(t, filepath.Join(dir, "docs"), "not a directory")
+
+	var out bytes.Buffer
+	if code := runOnce(cfg, &out); code != 1 {
+		t.Fatalf("runOnce() = %d, want 1\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "failed  a.pdf") || !strings.Contains(out.String(), "1 failed") {
+		t.Errorf("failure not reported:\n%s", out.String())
+	}
+	if !exists(filepath.Join(cfg.WatchDir, "a.pdf")) {
+		t.Error("source removed despite failure")
+	}
+}
+
+// Test runOnce exits 1 when a file is still changing at max_wait_seconds
+func TestRunOnceNotStable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	cfg.Stability = []string{checkSize}
+	oneSec := 1
+	cfg.Rules[0].MaxWaitSec = &oneSec
+	path := filepath.Join(cfg.WatchDir, "big.pdf")
+	writeFile(t, path, "x")
+	stop := make(chan struct{})
+	defer close(stop)
+	go growFile(path, stop)
+
+	var out bytes.Buffer
+	if code := runOnce(cfg, &out); code != 1 {
+		t.Fatalf("runOnce() = %d, want 1\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "failed  big.pdf") {
+		t.Errorf("unstable file not reported as failed:\n%s", out.String())
+	}
+}
+
+// Test runOnce files old files without waiting, like the initial scan
+func TestRunOnceOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	cfg.SettleMillis = 5000
+	for _, n := range []string{"a.pdf", "b.pdf"} {
+		p := filepath.Join(cfg.WatchDir, n)
+		writeFile(t, p, "%PDF-1.4")
+		hourAgo := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(p, hourAgo, hourAgo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	var out bytes.Buffer
+	if code := runOnce(cfg, &out); code != 0 {
+		t.Fatalf("runOnce() = %d, want 0\n%s", code, out.String())
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("runOnce took %v, waited for old files to settle", waited)
+	}
+	if !strings.Contains(out.String(), "2 files, 2 filed") {
+		t.Errorf("summary:\n%s", out.String())
+	}
+}
+
+// Test runOnce in email digest mode sends the report instead of dropping it
+func TestRunOnceEmailDigest(t *testing.T) {
+	host, port, msgs := fakeSMTP(t)
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	cfg.Email = testEmailConfig(t, host, port, "digest")
+	writeFile(t, filepath.Join(cfg.WatchDir, "a.pdf"), "%PDF-1.4")
+
+	dailyReport.take(time.Now()) // reset
+	var out bytes.Buffer
+	if code := runOnce(cfg, &out); code != 0 {
+		t.Fatalf("runOnce() = %d, want 0\n%s", code, out.String())
+	}
+	if m := receive(t, msgs); !strings.Contains(m.data, "a.pdf") {
+		t.Errorf("report doesn't list a.pdf:\n%s", m.data)
+	}
+}
+
+// Test run parses the config path before or after -once
+func TestRunCommandArgs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	if err := os.MkdirAll(cfg.WatchDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "cfg.yaml")
+	writeFile(t, cfgPath, "watch_dir: "+cfg.WatchDir+"\nhistory_file: \"\"\njournal_file: \"\"\nnotifications: false\n")
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-once", cfgPath}, 0},
+		{[]string{cfgPath, "--once"}, 0},
+		{[]string{"-once", "-config", cfgPath}, 0},
+		{[]string{"-once"}, 2},
+		{[]string{"-once", cfgPath, "extra"}, 2},
+		{[]string{"-once", filepath.Join(dir, "missing.yaml")}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := runCommand(tt.args); got != tt.want {
+				t.Errorf("runCommand(%v) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Initial scan orders
+const (
+	scanOldest = "oldest" // oldest mtime first
+	scanNewest = "newest" // newest mtime first
+)
+
+func normalizeInitialScan(cfg *Config) error {
+	o := strings.ToLower(strings.TrimSpace(cfg.ScanOrder))
+	if o == "" {
+		o = scanOldest
+	}
+	if o != scanOldest && o != scanNewest {
+		return fmt.Errorf("invalid initial_scan_order %q (want oldest or newest)", cfg.ScanOrder)
+	}
+	cfg.ScanOrder = o
+	if cfg.ScanOldSec < 0 {
+		return fmt.Errorf("initial_scan_old_seconds must not be negative")
+	}
+	return nil
+}
+
+// scanFile is a file found by the initial scan.
+type scanFile struct {
+	path  string
+	mtime time.Time
+}
+
+// initialScan files what was in the watch directory at startup, in
+// initial_scan_order. The watcher is already running, so nothing that
+// arrives meanwhile is missed. Files not modified for initial_scan_old_seconds
+// are taken as complete and filed one after another without a stability
+// wait; newer ones, and old ones with a browser download in progress beside
+// them, go through the normal pipeline. It returns the number of files found.
+func (d *daemon) initialScan() int {
+	cfg, _ := d.config()
+	files, err := scanFiles(cfg.WatchDir, cfg.ScanOrder)
+	if err != nil {
+		slog.Error("initial scan failed", "event", "scan_failed", "dest", cfg.WatchDir, "error", err)
+		return 0
+	}
+	oldBefore := time.Now().Add(-time.Duration(cfg.ScanOldSec) * time.Second)
+	old := 0
+	for _, f := range files {
+		// Waiting happens in the background rather than holding up the scan
+		if cfg.needsWait(f, oldBefore) {
+			d.dispatch(f.path, false)
+			continue
+		}
+		old++
+		if run := d.job(f.path, true); run != nil {
+			run()
+		}
+	}
+	slog.Info("initial scan", "event", "initial_scan", "dest", cfg.WatchDir, "files", len(files), "old", old)
+	return len(files)
+}
+
+// needsWait reports whether a file found at startup may still be
+// downloading: it was modified after oldBefore, or it is an old placeholder,
+// such as Firefox's, whose download is still in progress beside it.
+func (cfg Config) needsWait(f scanFile, oldBefore time.Time) bool {
+	if f.mtime.After(oldBefore) {
+		return true
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	side, _ := findSidecar(f.path, fi, cfg.IgnoreExts)
+	return side != ""
+}
+
+// scanFiles lists the files in dir sorted by mtime in the given order.
+func scanFiles(dir, order string) ([]scanFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []scanFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		fi, errInfo := e.Info()
+		if errInfo != nil {
+			continue // gone already
+		}
+		files = append(files, scanFile{path: filepath.Join(dir, e.Name()), mtime: fi.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if order == scanNewest {
+			return files[i].mtime.After(files[j].mtime)
+		}
+		return files[i].mtime.Before(files[j].mtime)
+	})
+	return files, nil
+}
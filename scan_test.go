@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test scanFiles sorts by mtime in either order and leaves out directories
+func TestScanFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, n := range []string{"b.pdf", "c.pdf", "a.pdf"} {
+		p := filepath.Join(dir, n)
+		writeFile(t, p, n)
+		mtime := now.Add(-time.Duration(i) * time.Hour) // b newest, a oldest
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{scanOldest, []string{"a.pdf", "c.pdf", "b.pdf"}},
+		{scanNewest, []string{"b.pdf", "c.pdf", "a.pdf"}},
+	}
+	for _, tt := range tests {
+		files, err := scanFiles(dir, tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, filepath.Base(f.path))
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] || got[2] != tt.want[2] {
+			t.Errorf("scanFiles(%s) = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
+
+// Test the initial scan files old files at once and waits for ones still being written
+func TestInitialScan(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	d.cfg.SettleMillis, d.cfg.PollMillis = 100, 10
+	d.cfg.Stability = []string{checkSize}
+	watch := d.cfg.WatchDir
+	docs := filepath.Join(filepath.Dir(watch), "docs")
+
+	old := filepath.Join(watch, "old.pdf")
+	writeFile(t, old, "done long ago")
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(watch, "fresh.pdf")
+	writeFile(t, fresh, "x")
+	stop := make(chan struct{})
+	go growFile(fresh, stop)
+	time.AfterFunc(300*time.Millisecond, func() { close(stop) })
+
+	if n := d.initialScan(); n != 2 {
+		t.Errorf("initialScan() = %d, want 2", n)
+	}
+	if !exists(filepath.Join(docs, "old.pdf")) {
+		t.Error("old file not filed by the scan")
+	}
+	if exists(filepath.Join(docs, "fresh.pdf")) {
+		t.Fatal("file still being written was filed without a stability wait")
+	}
+	dest := filepath.Join(docs, "fresh.pdf")
+	for deadline := time.Now().Add(5 * time.Second); !exists(dest); {
+		if time.Now().After(deadline) {
+			t.Fatal("fresh file never filed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Test an old placeholder with a download in progress beside it doesn't hold
+// up the scan of the old files after it, even without a wait limit
+func TestInitialScanSidecar(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	d.cfg.SettleMillis, d.cfg.PollMillis, d.cfg.MaxWaitSec = 100, 10, 0
+	d.cfg.Stability = []string{checkSize}
+	watch := d.cfg.WatchDir
+	docs := filepath.Join(filepath.Dir(watch), "docs")
+
+	// Oldest first: the placeholder comes before the other old files
+	for i, n := range []string{"big.pdf", "a.pdf", "b.pdf"} {
+		p := filepath.Join(watch, n)
+		content := n
+		if n == "big.pdf" {
+			content = "" // Firefox reserves the name with an empty file
+		}
+		writeFile(t, p, content)
+		mtime := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part := filepath.Join(watch, "big.pdf.part")
+	writeFile(t, part, "x")
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go growFile(part, stop)
+
+	done := make(chan int)
+	go func() { done <- d.initialScan() }()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("initial scan blocked on a download in progress")
+	}
+	for _, n := range []string{"a.pdf", "b.pdf"} {
+		if !exists(filepath.Join(docs, n)) {
+			t.Errorf("%s not filed by the scan", n)
+		}
+	}
+	if exists(filepath.Join(docs, "big.pdf")) {
+		t.Error("placeholder filed while its download is in progress")
+	}
+}
+
+// Test normalizeInitialScan defaults and validation
+func TestNormalizeInitialScan(t *testing.T) {
+	tests := []struct {
+		order   string
+		oldSec  int
+		want    string
+		wantErr bool
+	}{
+		{"", 60, scanOldest, false},
+		{"Newest", 0, scanNewest, false},
+		{"random", 60, "", true},
+		{"oldest", -1, "", true},
+	}
+	for _, tt := range tests {
+		cfg := Config{ScanOrder: tt.order, ScanOldSec: tt.oldSec}
+		err := normalizeInitialScan(&cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeInitialScan(%q, %d) error = %v, wantErr %v", tt.order, tt.oldSec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.ScanOrder != tt.want {
+			t.Errorf("ScanOrder = %q, want %q", cfg.ScanOrder, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// findSidecar looks for a browser's in-progress download next to path, which
+// means path is a placeholder or about to be replaced:
+//
+//   - Chrome, Edge: "foo.crdownload", renamed to "foo" when done
+//   - Firefox: an empty "foo" reserving the name, plus "foo.part" (or a
+//     randomly named "*.part") that is renamed over it when done
+//   - Safari: a "foo.download" bundle directory, replaced by "foo" when done
+//
+// partialExts (ignore_exts) adds "foo<ext>" for any other extension. It
+// returns the sidecar path and the browser it points to, or "" if there is none.
+func findSidecar(path string, fi os.FileInfo, partialExts []string) (sidecar, browser string) {
+	known := []struct{ ext, browser string }{
+		{".crdownload", "chrome"},
+		{".part", "firefox"},
+		{".download", "safari"},
+	}
+	for _, k := range known {
+		if _, err := os.Lstat(path + k.ext); err == nil {
+			return path + k.ext, k.browser
+		}
+	}
+	for _, ext := range partialExts {
+		if _, err := os.Lstat(path + ext); err == nil {
+			return path + ext, "download"
+		}
+	}
+	// Firefox sometimes gives the .part file a random name, so an empty
+	// placeholder can only be tied to it by being in the same directory.
+	// Failed downloads leave stale .part files behind, so only one that is
+	// still being written counts.
+	if fi.Size() == 0 {
+		parts, _ := filepath.Glob(filepath.Join(globEscape(filepath.Dir(path)), "*.part"))
+		for _, p := range parts {
+			if pi, err := os.Stat(p); err == nil && time.Since(pi.ModTime()) < partActiveWithin {
+				return p, "firefox"
+			}
+		}
+	}
+	return "", ""
+}
+
+// partActiveWithin is how recently a randomly named .part file must have
+// been written to for an empty file beside it to count as its placeholder.
+const partActiveWithin = time.Minute
+
+// globEscape quotes glob metacharacters in a literal path.
+func globEscape(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
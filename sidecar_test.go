@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test findSidecar recognizes each browser's in-progress download layout
+func TestFindSidecar(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string // content of foo.pdf
+		others      []string
+		dirs        []string
+		wantSidecar string
+		wantBrowser string
+	}{
+		{"none", "data", nil, nil, "", ""},
+		{"chrome", "", []string{"foo.pdf.crdownload"}, nil, "foo.pdf.crdownload", "chrome"},
+		{"firefox", "", []string{"foo.pdf.part"}, nil, "foo.pdf.part", "firefox"},
+		{"firefox random part name", "", []string{"Xa3kP9.part"}, nil, "Xa3kP9.part", "firefox"},
+		{"unrelated part beside complete file", "data", []string{"Xa3kP9.part"}, nil, "", ""},
+		{"safari bundle", "", nil, []string{"foo.pdf.download"}, "foo.pdf.download", "safari"},
+		{"ignore_exts", "", []string{"foo.pdf.partial"}, nil, "foo.pdf.partial", "download"},
+		{"other file's crdownload", "data", []string{"bar.pdf.crdownload"}, nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "foo.pdf")
+			writeFile(t, path, tt.file)
+			for _, o := range tt.others {
+				writeFile(t, filepath.Join(dir, o), "partial")
+			}
+			for _, d := range tt.dirs {
+				if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			side, browser := findSidecar(path, fi, defaultConfig().IgnoreExts)
+			if filepath.Base(side) != filepath.Base(tt.wantSidecar) || browser != tt.wantBrowser {
+				t.Errorf("findSidecar() = %q, %q; want %q, %q", side, browser, tt.wantSidecar, tt.wantBrowser)
+			}
+		})
+	}
+}
+
+// Test an empty file isn't tied to a randomly named .part left over from a
+// failed download, only to one still being written
+func TestFindSidecarStalePart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "empty.txt")
+	writeFile(t, path, "")
+	stale := filepath.Join(dir, "Xa3kP9.part")
+	writeFile(t, stale, "abandoned")
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(stale, dayAgo, dayAgo); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if side, browser := findSidecar(path, fi, defaultConfig().IgnoreExts); side != "" {
+		t.Errorf("findSidecar() = %q, %q beside a stale .part; want none", side, browser)
+	}
+
+	active := filepath.Join(dir, "Qm7zT2.part")
+	writeFile(t, active, "downloading")
+	if side, _ := findSidecar(path, fi, defaultConfig().IgnoreExts); side != active {
+		t.Errorf("findSidecar() = %q, want the active %q", side, active)
+	}
+}
+
+// Test waitUntilStable holds a Firefox placeholder until the .part is renamed over it
+func TestWaitUntilStableSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "movie.mkv")
+	part := path + ".part"
+	writeFile(t, path, "")
+	writeFile(t, part, "first half")
+
+	busy := 300 * time.Millisecond
+	go func() {
+		time.Sleep(busy)
+		_ = os.WriteFile(part, []byte("first half, second half"), 0o600)
+		_ = os.Rename(part, path)
+	}()
+
+	sc := fastStability(checkSize)
+	sc.partials = defaultConfig().IgnoreExts
+	start := time.Now()
+	if err := waitUntilStable(path, sc); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < busy {
+		t.Errorf("returned after %v, before the download finished", waited)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "first half, second half" {
+		t.Errorf("content = %q (err %v), want the finished download", b, err)
+	}
+}
+
+// Test the initial scan doesn't file a Chrome placeholder, and dry runs report it as in progress
+func TestHandleFileSidecarSkipStability(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	path := filepath.Join(cfg.WatchDir, "report.pdf")
+	writeFile(t, path, "")
+	writeFile(t, path+".crdownload", "partial")
+
+	res := handleFile(path, cfg, nil, handleOptions{skipStability: true, dryRun: true})
+	if res.Status != resultSkipped || res.Err != errDownloading {
+		t.Errorf("dry run = %+v, want skipped with %v", res, errDownloading)
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = os.Rename(path+".crdownload", path)
+	}()
+	res = handleFile(path, cfg, nil, handleOptions{skipStability: true})
+	if res.Status != resultFiled {
+		t.Fatalf("handleFile = %+v, want filed", res)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "docs", "report.pdf")); err != nil || string(b) != "partial" {
+		t.Errorf("filed content = %q (err %v), want the finished download", b, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Files left in the watch dir because no destination had room (path -> time held).
+// The daemon re-dispatches them every hold_retry_seconds.
+var held sync.Map
+
+// heldFiles returns the number of files waiting for space.
+func heldFiles() int {
+	n := 0
+	held.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// spaceNeeded returns how many free bytes filing a file of size with r needs:
+// the file plus the rule's min_free_mb. A move within one filesystem is a
+// rename and needs nothing.
+func spaceNeeded(r *Rule, src, dir string, size int64) uint64 {
+	if r.Action == "move" && sameFilesystem(src, dir) {
+		return 0
+	}
+	return uint64(max(size, 0)) + uint64(max(r.MinFreeMB, 0))<<20
+}
+
+// checkSpace reports whether the destination of r has room for src. It
+// returns the bytes needed and available for logging; where free space can't
+// be determined it assumes there is room.
+func checkSpace(r *Rule, src string, size int64) (ok bool, need, avail uint64, err error) {
+	dir := existingParent(r.Dest)
+	need = spaceNeeded(r, src, dir, size)
+	if need == 0 {
+		return true, 0, 0, nil
+	}
+	avail, err = freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true, need, 0, nil
+	}
+	if err != nil {
+		return false, need, 0, err
+	}
+	return avail >= need, need, avail, nil
+}
+
+// existingParent returns dir, or its nearest ancestor that exists, since
+// destination directories are often created on first use.
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// ruleWithSpace returns r if its destination has room for path, otherwise the
+// first rule along its fallback_rule chain that does, or nil to hold the file.
+func ruleWithSpace(path string, r *Rule, rules []Rule) *Rule {
+	st, err := os.Stat(path)
+	if err != nil {
+		return r // vanished; filing will report it
+	}
+	seen := make(map[string]bool)
+	for cur := r; cur != nil && !seen[cur.Name]; cur = findRule(rules, cur.FallbackRule) {
+		seen[cur.Name] = true
+		ok, need, avail, errSpace := checkSpace(cur, path, st.Size())
+		if errSpace != nil {
+			slog.Warn("free space check failed", "event", "space_check_failed", "file", st.Name(), "rule", cur.Name, "dest", cur.Dest, "error", errSpace)
+		}
+		if ok {
+			if cur != r {
+				slog.Info("not enough space, using fallback rule", "event", "space_fallback", "file", st.Name(), "rule", r.Name, "dest", cur.Name)
+			}
+			return cur
+		}
+		slog.Debug("not enough space", "event", "space_low", "file", st.Name(), "rule", cur.Name, "dest", cur.Dest,
+			"bytes", need, "error", fmt.Sprintf("%d bytes free", avail))
+		if cur.FallbackRule == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// holdFile marks path as waiting for space. Only the first hold is logged.
+func holdFile(path string, r *Rule) {
+	if _, already := held.LoadOrStore(path, time.Now()); already {
+		return
+	}
+	metricFailures.WithLabelValues("space").Inc()
+	events.publish(FileEvent{Type: eventHeld, Path: path, Rule: r.Name, Dest: r.Dest, Stage: "space"})
+	slog.Warn("not enough space, holding file", "event", "held", "file", filepath.Base(path), "rule", r.Name, "dest", r.Dest)
+}
+
+// releaseFile clears the hold on path once it can be filed.
+func releaseFile(path string) {
+	if v, ok := held.LoadAndDelete(path); ok {
+		slog.Info("space available, filing held file", "event", "released", "file", filepath.Base(path), "duration", time.Since(v.(time.Time)))
+	}
+}
+
+// retryHeld re-dispatches held files every interval, forgetting any that
+// have disappeared from the watch dir.
+func (d *daemon) retryHeld(interval time.Duration) {
+	for range time.Tick(interval) {
+		held.Range(func(k, _ any) bool {
+			path := k.(string)
+			if _, err := os.Stat(path); err != nil {
+				held.Delete(path)
+				return true
+			}
+			d.dispatch(path, true)
+			return true
+		})
+	}
+}
+
+// validateFallbacks checks that every fallback_rule names another rule.
+func validateFallbacks(rules []Rule) error {
+	for _, r := range rules {
+		if r.FallbackRule == "" {
+			continue
+		}
+		if r.FallbackRule == r.Name || findRule(rules, r.FallbackRule) == nil {
+			return fmt.Errorf("rule %q has invalid fallback_rule %q", r.Name, r.FallbackRule)
+		}
+	}
+	return nil
+}
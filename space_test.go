@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const hugeMB = 1 << 40 // more free space than any test machine has
+
+// Test a copy needs room for the file plus min_free_mb, a same-filesystem move nothing
+func TestSpaceNeeded(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.bin")
+	writeFile(t, src, "x")
+
+	tests := []struct {
+		name string
+		rule Rule
+		want uint64
+	}{
+		{"copy", Rule{Action: "copy"}, 1000},
+		{"copy with min free", Rule{Action: "copy", MinFreeMB: 2}, 1000 + 2<<20},
+		{"same filesystem move", Rule{Action: "move", MinFreeMB: 2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spaceNeeded(&tt.rule, src, dir, 1000); got != tt.want {
+				t.Errorf("spaceNeeded() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test ruleWithSpace falls back along fallback_rule and holds when nothing fits
+func TestRuleWithSpace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "watch", "big.iso")
+	writeFile(t, src, "iso")
+
+	rules := []Rule{
+		{Name: "NAS", Action: "copy", Dest: filepath.Join(dir, "nas", "isos"), MinFreeMB: hugeMB, FallbackRule: "USB"},
+		{Name: "USB", Action: "copy", Dest: filepath.Join(dir, "usb"), MinFreeMB: hugeMB, FallbackRule: "Local"},
+		{Name: "Local", Action: "copy", Dest: filepath.Join(dir, "local")},
+		{Name: "Loop", Action: "copy", Dest: dir, MinFreeMB: hugeMB, FallbackRule: "Loop2"},
+		{Name: "Loop2", Action: "copy", Dest: dir, MinFreeMB: hugeMB, FallbackRule: "Loop"},
+	}
+
+	if got := ruleWithSpace(src, &rules[2], rules); got != &rules[2] {
+		t.Errorf("rule with room: got %v, want Local", got)
+	}
+	if got := ruleWithSpace(src, &rules[0], rules); got == nil || got.Name != "Local" {
+		t.Errorf("fallback chain: got %v, want Local", got)
+	}
+	if got := ruleWithSpace(src, &rules[3], rules); got != nil {
+		t.Errorf("fallback loop without room: got %v, want nil", got)
+	}
+}
+
+// Test a held file stays put and is filed once there is room
+func TestHandleFileHoldsWithoutSpace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "watch", "movie.mkv")
+	dest := filepath.Join(dir, "videos")
+	writeFile(t, src, "matroska")
+	t.Cleanup(func() { held.Delete(src) })
+
+	cfg := defaultConfig()
+	cfg.HistoryFile = ""
+	cfg.Notifications = false
+	cfg.Rules = []Rule{{Name: "Videos", Extensions: []string{"mkv"}, Action: "copy", Dest: dest, MinFreeMB: hugeMB}}
+
+	handleFile(src, cfg, nil, handleOptions{skipStability: true})
+	if _, ok := held.Load(src); !ok {
+		t.Fatal("file not held")
+	}
+	if exists(filepath.Join(dest, "movie.mkv")) {
+		t.Fatal("file copied despite lack of space")
+	}
+	if heldFiles() != 1 {
+		t.Errorf("heldFiles() = %d, want 1", heldFiles())
+	}
+
+	cfg.Rules[0].MinFreeMB = 0
+	handleFile(src, cfg, nil, handleOptions{skipStability: true})
+	if _, ok := held.Load(src); ok {
+		t.Error("file still held after filing")
+	}
+	if !exists(filepath.Join(dest, "movie.mkv")) {
+		t.Error("held file not filed once there was room")
+	}
+}
+
+// Test validateFallbacks rejects unknown and self references
+func TestValidateFallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr bool
+	}{
+		{"none", []Rule{{Name: "A"}}, false},
+		{"valid", []Rule{{Name: "A", FallbackRule: "B"}, {Name: "B"}}, false},
+		{"unknown", []Rule{{Name: "A", FallbackRule: "C"}}, true},
+		{"self", []Rule{{Name: "A", FallbackRule: "A"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateFallbacks(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("validateFallbacks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test existingParent walks up to a directory that exists
+func TestExistingParent(t *testing.T) {
+	dir := t.TempDir()
+	if got := existingParent(filepath.Join(dir, "a", "b", "c")); got != dir {
+		t.Errorf("existingParent() = %q, want %q", got, dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := existingParent(filepath.Join(dir, "a", "b")); got != filepath.Join(dir, "a") {
+		t.Errorf("existingParent() = %q, want %q", got, filepath.Join(dir, "a"))
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Stability checks, combined: a file is stable once every enabled check holds
+// for the whole settle window.
+const (
+	checkSize    = "size"    // size unchanged
+	checkMtime   = "mtime"   // modification time unchanged; catches preallocated files being filled in
+	checkWriters = "writers" // no process has the file open for writing (Linux /proc only)
+)
+
+// stabilityConfig is how handleFile waits for a file to be complete.
+type stabilityConfig struct {
+	settle   time.Duration
+	poll     time.Duration
+	maxWait  time.Duration // 0 waits as long as it takes
+	checks   []string
+	partials []string        // partial download extensions, for findSidecar
+	touched  <-chan struct{} // changes seen by the watcher; nil polls every poll interval instead
+}
+
+// errNotStable means a file was still changing when the maximum wait ran out.
+var errNotStable = errors.New("file did not stabilize")
+
+// stability returns the stability settings in cfg, with rule r's overrides
+// applied if r isn't nil.
+func (cfg Config) stability(r *Rule) stabilityConfig {
+	settle, poll, maxWait := cfg.SettleMillis, cfg.PollMillis, cfg.MaxWaitSec
+	if r != nil {
+		if r.SettleMillis != nil {
+			settle = *r.SettleMillis
+		}
+		if r.PollMillis != nil {
+			poll = *r.PollMillis
+		}
+		if r.MaxWaitSec != nil {
+			maxWait = *r.MaxWaitSec
+		}
+	}
+	return stabilityConfig{
+		settle:   time.Duration(settle) * time.Millisecond,
+		poll:     time.Duration(poll) * time.Millisecond,
+		maxWait:  time.Duration(maxWait) * time.Second,
+		checks:   cfg.Stability,
+		partials: cfg.IgnoreExts,
+	}
+}
+
+// validateStabilityTimes checks the global and per-rule settle, poll and
+// max-wait settings.
+func validateStabilityTimes(cfg Config) error {
+	if cfg.SettleMillis < 0 || cfg.PollMillis <= 0 || cfg.MaxWaitSec < 0 {
+		return errors.New("settle_millis and max_wait_seconds must not be negative, poll_millis must be positive")
+	}
+	for _, r := range cfg.Rules {
+		if (r.SettleMillis != nil && *r.SettleMillis < 0) || (r.PollMillis != nil && *r.PollMillis <= 0) || (r.MaxWaitSec != nil && *r.MaxWaitSec < 0) {
+			return fmt.Errorf("rule %q: settle_millis and max_wait_seconds must not be negative, poll_millis must be positive", r.Name)
+		}
+	}
+	return nil
+}
+
+func normalizeStabilityChecks(checks []string) error {
+	if len(checks) == 0 {
+		return errors.New("stability_checks must not be empty")
+	}
+	for i, c := range checks {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != checkSize && c != checkMtime && c != checkWriters {
+			return fmt.Errorf("invalid stability check %q (want size, mtime or writers)", checks[i])
+		}
+		checks[i] = c
+	}
+	return nil
+}
+
+// fileSnapshot holds the attributes the enabled checks compare between polls.
+type fileSnapshot struct {
+	size  int64
+	mtime time.Time
+}
+
+func snapshot(fi os.FileInfo, checks []string) fileSnapshot {
+	var s fileSnapshot
+	if slices.Contains(checks, checkSize) {
+		s.size = fi.Size()
+	}
+	if slices.Contains(checks, checkMtime) {
+		s.mtime = fi.ModTime()
+	}
+	return s
+}
+
+func (s fileSnapshot) equal(o fileSnapshot) bool {
+	return s.size == o.size && s.mtime.Equal(o.mtime)
+}
+
+// waitUntilStable returns once path is complete, or errNotStable after
+// sc.maxWait. With sc.touched it waits for the watcher to go quiet for the
+// settle window; otherwise it polls.
+func waitUntilStable(path string, sc stabilityConfig) error {
+	if sc.touched != nil {
+		return settleOnEvents(path, sc)
+	}
+	// Consider stable when size and mtime are unchanged across the settle window
+	// and nobody is writing to the file.
+	start := time.Now()
+	var last fileSnapshot
+	first := true
+	var stableFor time.Duration
+	waiting := false
+
+	for sc.maxWait <= 0 || time.Since(start) < sc.maxWait {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		// A browser's partial file next to this one will replace it or be
+		// renamed to it, so nothing counts until it is gone
+		if downloading(path, fi, sc.partials, &waiting) {
+			first, stableFor = true, 0
+			time.Sleep(sc.poll)
+			continue
+		}
+		snap := snapshot(fi, sc.checks)
+		// With no settle window there's nothing to compare against
+		if sc.settle <= 0 || (!first && snap.equal(last)) {
+			stableFor += sc.poll
+			if stableFor >= sc.settle {
+				if !beingWritten(path, sc.checks) {
+					return nil
+				}
+				stableFor = 0
+			}
+		} else {
+			last = snap
+			first = false
+			stableFor = 0
+		}
+		time.Sleep(sc.poll)
+	}
+	return fmt.Errorf("%w within %v", errNotStable, sc.maxWait)
+}
+
+// settleOnEvents waits until the watcher has reported no change to path for
+// the settle window, so an idle download costs one wakeup per window rather
+// than one per poll. At the end of each window the file is checked as the
+// polling loop would, which catches writes the watcher misses (network
+// filesystems, mmap).
+func settleOnEvents(path string, sc stabilityConfig) error {
+	var giveUp <-chan time.Time
+	if sc.maxWait > 0 {
+		t := time.NewTimer(sc.maxWait)
+		defer t.Stop()
+		giveUp = t.C
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	last := snapshot(fi, sc.checks)
+	waiting := false
+	window := time.NewTimer(sc.settle)
+	defer window.Stop()
+
+	for {
+		select {
+		case <-sc.touched:
+			window.Reset(sc.settle)
+		case <-giveUp:
+			return fmt.Errorf("%w within %v", errNotStable, sc.maxWait)
+		case <-window.C:
+			fi, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+			snap := snapshot(fi, sc.checks)
+			if !downloading(path, fi, sc.partials, &waiting) && snap.equal(last) && !beingWritten(path, sc.checks) {
+				return nil
+			}
+			last = snap
+			window.Reset(sc.settle)
+		}
+	}
+}
+
+// downloading reports whether a browser sidecar sits next to path, logging
+// the first time in a row it does (tracked in *waiting).
+func downloading(path string, fi os.FileInfo, partials []string, waiting *bool) bool {
+	side, browser := findSidecar(path, fi, partials)
+	if side == "" {
+		*waiting = false
+		return false
+	}
+	if !*waiting {
+		slog.Debug("waiting for download to finish", "event", "download_in_progress", "file", filepath.Base(path), "browser", browser, "sidecar", filepath.Base(side))
+		*waiting = true
+	}
+	return true
+}
+
+// beingWritten runs the writers check if it is enabled. Scanning /proc is
+// the expensive check, so it only confirms what the cheap ones already say.
+func beingWritten(path string, checks []string) bool {
+	if !slices.Contains(checks, checkWriters) {
+		return false
+	}
+	writing, err := openForWriting(path)
+	if err != nil {
+		slog.Debug("open writer check failed", "event", "writers_check_failed", "file", filepath.Base(path), "error", err)
+		return false
+	}
+	return writing
+}
+
+// Root of the proc filesystem; a variable so tests can point it elsewhere
+var procRoot = "/proc"
+
+// openForWriting reports whether any process we can inspect has path open
+// for writing, by scanning /proc/*/fd. Other users' processes are invisible
+// unless we run as root. It always reports false outside Linux.
+func openForWriting(path string) (bool, error) {
+	if runtime.GOOS != "linux" {
+		return false, nil
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return false, err
+	}
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range procs {
+		if _, errPid := strconv.Atoi(p.Name()); errPid != nil || !p.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, p.Name(), "fd")
+		fds, errFds := os.ReadDir(fdDir)
+		if errFds != nil {
+			continue // exited, or another user's process
+		}
+		for _, fd := range fds {
+			link, errLink := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if errLink != nil || link != target {
+				continue
+			}
+			if fdWritable(filepath.Join(procRoot, p.Name(), "fdinfo", fd.Name())) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// fdWritable reads the open flags from a /proc/<pid>/fdinfo/<fd> file. If
+// they can't be read the descriptor is assumed to be a writer.
+func fdWritable(fdinfo string) bool {
+	f, err := os.Open(fdinfo)
+	if err != nil {
+		return true
+	}
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "flags:")
+		if !ok {
+			continue
+		}
+		flags, errParse := strconv.ParseUint(strings.TrimSpace(v), 8, 64)
+		if errParse != nil {
+			return true
+		}
+		return flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	}
+	return true
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func fastStability(checks ...string) stabilityConfig {
+	return stabilityConfig{settle: 100 * time.Millisecond, poll: 10 * time.Millisecond, checks: checks}
+}
+
+// Test a preallocated file being filled in (same size, changing mtime) waits for mtime
+func TestWaitUntilStableMtime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ubuntu.iso")
+	writeFile(t, path, "preallocated-full-size")
+
+	busy := 400 * time.Millisecond
+	began := time.Now()
+	go func() {
+		mtime := time.Now()
+		for end := time.Now().Add(busy); time.Now().Before(end); {
+			mtime = mtime.Add(time.Second)
+			_ = os.Chtimes(path, mtime, mtime)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	if err := waitUntilStable(path, fastStability(checkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= busy {
+		t.Errorf("size-only check took %v; expected it to miss the writes", time.Since(start))
+	}
+
+	if err := waitUntilStable(path, fastStability(checkSize, checkMtime)); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(began); waited < busy {
+		t.Errorf("mtime check returned %v after the writes began, while the file was still changing", waited)
+	}
+}
+
+// Test openForWriting sees our own write descriptor but not a read-only one
+func TestOpenForWriting(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open writer detection needs /proc")
+	}
+	path := filepath.Join(t.TempDir(), "download.bin")
+	writeFile(t, path, "data")
+
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+	if writing, err := openForWriting(path); err != nil || writing {
+		t.Errorf("read-only open: openForWriting() = %v, %v; want false", writing, err)
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writing, err := openForWriting(path); err != nil || !writing {
+		t.Errorf("write open: openForWriting() = %v, %v; want true", writing, err)
+	}
+	_ = w.Close()
+	if writing, err := openForWriting(path); err != nil || writing {
+		t.Errorf("after close: openForWriting() = %v, %v; want false", writing, err)
+	}
+}
+
+// Test a stalled download that is still open for writing isn't treated as stable
+func TestWaitUntilStableWriters(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open writer detection needs /proc")
+	}
+	path := filepath.Join(t.TempDir(), "stalled.zip")
+	writeFile(t, path, "half")
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := 400 * time.Millisecond
+	time.AfterFunc(hold, func() { _ = w.Close() })
+
+	start := time.Now()
+	if err := waitUntilStable(path, fastStability(checkSize, checkMtime, checkWriters)); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < hold {
+		t.Errorf("returned after %v while the file was still open for writing", waited)
+	}
+}
+
+// Test normalizeStabilityChecks
+func TestNormalizeStabilityChecks(t *testing.T) {
+	tests := []struct {
+		checks  []string
+		wantErr bool
+	}{
+		{[]string{"size"}, false},
+		{[]string{" Size ", "MTIME", "writers"}, false},
+		{nil, true},
+		{[]string{"size", "lsof"}, true},
+	}
+	for _, tt := range tests {
+		if err := normalizeStabilityChecks(tt.checks); (err != nil) != tt.wantErr {
+			t.Errorf("normalizeStabilityChecks(%v) error = %v, wantErr %v", tt.checks, err, tt.wantErr)
+		}
+	}
+}
+
+// Test rule overrides replace only the stability settings they set
+func TestStabilityRuleOverrides(t *testing.T) {
+	zero, fast, minute := 0, 50, 60000
+	cfg := defaultConfig()
+	tests := []struct {
+		name                  string
+		rule                  *Rule
+		settle, poll, maxWait time.Duration
+	}{
+		{"no rule", nil, 1500 * time.Millisecond, 250 * time.Millisecond, 5 * time.Minute},
+		{"no overrides", &Rule{Name: "Docs"}, 1500 * time.Millisecond, 250 * time.Millisecond, 5 * time.Minute},
+		{"screenshots", &Rule{Name: "Screenshots", SettleMillis: &zero, PollMillis: &fast}, 0, 50 * time.Millisecond, 5 * time.Minute},
+		{"isos", &Rule{Name: "ISOs", SettleMillis: &minute, MaxWaitSec: &zero}, time.Minute, 250 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := cfg.stability(tt.rule)
+			if sc.settle != tt.settle || sc.poll != tt.poll || sc.maxWait != tt.maxWait {
+				t.Errorf("stability() = settle %v poll %v maxWait %v; want %v %v %v", sc.settle, sc.poll, sc.maxWait, tt.settle, tt.poll, tt.maxWait)
+			}
+		})
+	}
+}
+
+// Test a zero settle window files at once, and the max wait gives up on a changing file
+func TestWaitUntilStableSettleAndMaxWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shot.png")
+	writeFile(t, path, "png")
+
+	sc := fastStability(checkSize)
+	sc.settle = 0
+	start := time.Now()
+	if err := waitUntilStable(path, sc); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > sc.poll {
+		t.Errorf("zero settle waited %v", waited)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go growFile(path, stop)
+	sc = fastStability(checkSize)
+	sc.maxWait = 200 * time.Millisecond
+	if err := waitUntilStable(path, sc); !errors.Is(err, errNotStable) {
+		t.Errorf("waitUntilStable() = %v, want %v", err, errNotStable)
+	}
+}
+
+// growFile appends to path every 10ms until stop is closed.
+func growFile(path string, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Millisecond):
+			if f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+				_, _ = f.WriteString("x")
+				_ = f.Close()
+			}
+		}
+	}
+}
+
+// Test the daemon re-queues a file that outlasts max_wait_seconds and files it once it settles
+func TestDispatchRequeuesUnstable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	cfg.Stability = []string{checkSize}
+	oneSec := 1
+	cfg.Rules[0].MaxWaitSec = &oneSec
+	path := filepath.Join(cfg.WatchDir, "big.pdf")
+	writeFile(t, path, "x")
+
+	stop := make(chan struct{})
+	go growFile(path, stop)
+	time.AfterFunc(1500*time.Millisecond, func() { close(stop) })
+
+	newDaemon("", cfg).dispatch(path, false)
+	dest := filepath.Join(dir, "docs", "big.pdf")
+	for deadline := time.Now().Add(5 * time.Second); !exists(dest); {
+		if time.Now().After(deadline) {
+			t.Fatal("file was dropped instead of re-queued")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Test loadConfig rejects negative waits and non-positive polls, globally and per rule
+func TestValidateStabilityTimes(t *testing.T) {
+	neg, zero := -1, 0
+	tests := []struct {
+		name    string
+		edit    func(*Config)
+		wantErr bool
+	}{
+		{"defaults", func(*Config) {}, false},
+		{"no max wait", func(c *Config) { c.MaxWaitSec = 0 }, false},
+		{"rule zero settle", func(c *Config) { c.Rules[0].SettleMillis = &zero }, false},
+		{"negative settle", func(c *Config) { c.SettleMillis = -1 }, true},
+		{"zero poll", func(c *Config) { c.PollMillis = 0 }, true},
+		{"rule zero poll", func(c *Config) { c.Rules[0].PollMillis = &zero }, true},
+		{"rule negative max wait", func(c *Config) { c.Rules[0].MaxWaitSec = &neg }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Rules = []Rule{{Name: "Docs"}}
+			tt.edit(&cfg)
+			if err := validateStabilityTimes(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateStabilityTimes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test watcher events hold off stability, and writes the watcher missed are still caught
+func TestSettleOnEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	writeFile(t, path, "frames")
+
+	busy := 300 * time.Millisecond
+	touched := make(chan struct{}, 1)
+	go func() {
+		for end := time.Now().Add(busy); time.Now().Before(end); {
+			select {
+			case touched <- struct{}{}:
+			default:
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	sc := fastStability(checkSize)
+	sc.touched = touched
+	start := time.Now()
+	if err := waitUntilStable(path, sc); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < busy {
+		t.Errorf("returned after %v while change events were still arriving", waited)
+	}
+
+	// No events at all, but the file grows
+	stop := make(chan struct{})
+	go growFile(path, stop)
+	time.AfterFunc(busy, func() { close(stop) })
+	sc.touched = make(chan struct{})
+	start = time.Now()
+	if err := waitUntilStable(path, sc); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < busy {
+		t.Errorf("returned after %v while the file was still growing", waited)
+	}
+
+	sc.maxWait = 100 * time.Millisecond
+	sc.settle = time.Second
+	if err := waitUntilStable(path, sc); !errors.Is(err, errNotStable) {
+		t.Errorf("waitUntilStable() = %v, want %v", err, errNotStable)
+	}
+}
+
+// Test touchFile wakes an in-flight file without blocking when a wakeup is pending
+func TestTouchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.bin")
+	touchFile(path) // not in flight: nothing to do
+
+	state := newFileState()
+	processing.Store(path, state)
+	defer processing.Delete(path)
+	touchFile(path)
+	touchFile(path)
+	select {
+	case <-state.touched:
+	default:
+		t.Fatal("touchFile didn't signal the in-flight file")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch backends
+const (
+	backendFsnotify = "fsnotify" // kernel notifications (inotify, FSEvents, ...)
+	backendPoll     = "poll"     // periodic directory listings, for NFS, SMB, sshfs and other FUSE mounts
+	backendAuto     = "auto"     // poll on network and FUSE filesystems (detected on Linux), fsnotify elsewhere
+)
+
+// dirWatcher reports changes in the directories added to it as fsnotify
+// events, whichever backend produces them.
+type dirWatcher interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+// notifyWatcher is the fsnotify backend.
+type notifyWatcher struct{ w *fsnotify.Watcher }
+
+func (n notifyWatcher) Add(dir string) error          { return n.w.Add(dir) }
+func (n notifyWatcher) Remove(dir string) error       { return n.w.Remove(dir) }
+func (n notifyWatcher) Close() error                  { return n.w.Close() }
+func (n notifyWatcher) Events() <-chan fsnotify.Event { return n.w.Events }
+func (n notifyWatcher) Errors() <-chan error          { return n.w.Errors }
+
+func normalizeWatchBackend(cfg *Config) error {
+	b := strings.ToLower(strings.TrimSpace(cfg.WatchBackend))
+	if b == "" {
+		b = backendFsnotify
+	}
+	if b != backendFsnotify && b != backendPoll && b != backendAuto {
+		return fmt.Errorf("invalid watch_backend %q (want fsnotify, poll or auto)", cfg.WatchBackend)
+	}
+	cfg.WatchBackend = b
+	if cfg.WatchPollSec <= 0 {
+		cfg.WatchPollSec = defaultConfig().WatchPollSec
+	}
+	return nil
+}
+
+// newDirWatcher starts the watch backend configured for cfg.WatchDir and
+// returns it with the backend's name.
+func newDirWatcher(cfg Config) (dirWatcher, string, error) {
+	backend := cfg.WatchBackend
+	if backend == backendAuto {
+		backend = backendFsnotify
+		if fs, remote := networkFS(cfg.WatchDir); remote {
+			slog.Info("network filesystem, polling", "event", "watch_backend", "dest", cfg.WatchDir, "fs", fs)
+			backend = backendPoll
+		}
+	}
+	if backend == backendPoll {
+		return newPollWatcher(time.Duration(cfg.WatchPollSec) * time.Second), backend, nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, backend, err
+	}
+	return notifyWatcher{w}, backend, nil
+}
+
+// pollEntry is what pollWatcher remembers about a file between listings.
+type pollEntry struct {
+	size  int64
+	mtime time.Time
+	mode  os.FileMode
+	inode uint64
+}
+
+// pollWatcher lists each directory every interval and reports the
+// differences: new names and replaced files (a changed inode, e.g. a rename
+// over the old file) as Create, size or mtime changes as Write, mode changes
+// as Chmod and vanished names as Remove. It sees changes made by other
+// machines on network filesystems, which inotify doesn't.
+type pollWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+
+	mu   sync.Mutex
+	dirs map[string]chan struct{} // dir -> closed to stop polling it
+	done chan struct{}
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	return &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event, 64),
+		errors:   make(chan error, 4),
+		dirs:     make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *pollWatcher) Events() <-chan fsnotify.Event { return p.events }
+func (p *pollWatcher) Errors() <-chan error          { return p.errors }
+
+// Add takes a first listing of dir, so files already there aren't reported,
+// and starts polling it.
+func (p *pollWatcher) Add(dir string) error {
+	known, err := listDir(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.dirs[dir]; ok {
+		return nil
+	}
+	stop := make(chan struct{})
+	p.dirs[dir] = stop
+	go p.poll(dir, known, stop)
+	return nil
+}
+
+func (p *pollWatcher) Remove(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stop, ok := p.dirs[dir]
+	if !ok {
+		return fmt.Errorf("%s: not watched", dir)
+	}
+	close(stop)
+	delete(p.dirs, dir)
+	return nil
+}
+
+func (p *pollWatcher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *pollWatcher) poll(dir string, known map[string]pollEntry, stop chan struct{}) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+		now, err := listDir(dir)
+		if err != nil {
+			p.send(nil, err, stop)
+			continue
+		}
+		for name, e := range now {
+			old, ok := known[name]
+			var op fsnotify.Op
+			switch {
+			case !ok || old.inode != e.inode:
+				op = fsnotify.Create
+			case old.size != e.size || !old.mtime.Equal(e.mtime):
+				op = fsnotify.Write
+			case old.mode != e.mode:
+				op = fsnotify.Chmod
+			default:
+				continue
+			}
+			p.send(&fsnotify.Event{Name: name, Op: op}, nil, stop)
+		}
+		for name := range known {
+			if _, ok := now[name]; !ok {
+				p.send(&fsnotify.Event{Name: name, Op: fsnotify.Remove}, nil, stop)
+			}
+		}
+		known = now
+	}
+}
+
+// send delivers an event or an error unless the watcher is being stopped.
+func (p *pollWatcher) send(ev *fsnotify.Event, err error, stop chan struct{}) {
+	if ev != nil {
+		select {
+		case p.events <- *ev:
+		case <-stop:
+		case <-p.done:
+		}
+		return
+	}
+	select {
+	case p.errors <- err:
+	case <-stop:
+	case <-p.done:
+	}
+}
+
+// listDir returns the entries of dir keyed by full path. Entries that vanish
+// while it runs are left out.
+func listDir(dir string) (map[string]pollEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]pollEntry, len(entries))
+	for _, de := range entries {
+		fi, errInfo := de.Info()
+		if errInfo != nil {
+			continue
+		}
+		files[filepath.Join(dir, de.Name())] = pollEntry{size: fi.Size(), mtime: fi.ModTime(), mode: fi.Mode(), inode: inode(fi)}
+	}
+	return files, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// nextEvent waits for the next event from w.
+func nextEvent(t *testing.T, w dirWatcher) fsnotify.Event {
+	t.Helper()
+	select {
+	case ev := <-w.Events():
+		return ev
+	case err := <-w.Errors():
+		t.Fatalf("watch error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return fsnotify.Event{}
+}
+
+// Test the poll backend reports creates, writes, chmods, replacements and removals, but not existing files
+func TestPollWatcher(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.pdf")
+	writeFile(t, old, "already here")
+
+	w := newPollWatcher(20 * time.Millisecond)
+	defer func() { _ = w.Close() }()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := filepath.Join(dir, "scan.pdf")
+	// Each step is a single change, so a listing can't catch it halfway
+	renameIn := func(content string) error {
+		tmp := filepath.Join(t.TempDir(), "new")
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, scan)
+	}
+	steps := []struct {
+		name string
+		do   func() error
+		want fsnotify.Op
+	}{
+		{"create", func() error { return renameIn("page 1") }, fsnotify.Create},
+		{"write", func() error {
+			f, err := os.OpenFile(scan, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				return err
+			}
+			_, err = f.WriteString(", page 2")
+			return errors.Join(err, f.Close())
+		}, fsnotify.Write},
+		{"chmod", func() error { return os.Chmod(scan, 0o600) }, fsnotify.Chmod},
+		{"replace", func() error { return renameIn("page 1, page 2") }, fsnotify.Create},
+		{"remove", func() error { return os.Remove(scan) }, fsnotify.Remove},
+	}
+	for _, st := range steps {
+		if st.name == "replace" && runtime.GOOS == "windows" {
+			continue // no inode numbers
+		}
+		if err := st.do(); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		ev := nextEvent(t, w)
+		if ev.Name != scan || ev.Op != st.want {
+			t.Errorf("%s: event = %v, want %v on %s", st.name, ev, st.want, scan)
+		}
+	}
+
+	if err := w.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "late.pdf"), "x")
+	select {
+	case ev := <-w.Events():
+		t.Errorf("event after Remove: %v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test normalizeWatchBackend defaults and validation
+func TestNormalizeWatchBackend(t *testing.T) {
+	tests := []struct {
+		backend  string
+		pollSec  int
+		want     string
+		wantPoll int
+		wantErr  bool
+	}{
+		{"", 0, backendFsnotify, 5, false},
+		{" Poll ", 30, backendPoll, 30, false},
+		{"auto", -1, backendAuto, 5, false},
+		{"inotify", 5, "", 0, true},
+	}
+	for _, tt := range tests {
+		cfg := Config{WatchBackend: tt.backend, WatchPollSec: tt.pollSec}
+		err := normalizeWatchBackend(&cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeWatchBackend(%q) error = %v, wantErr %v", tt.backend, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (cfg.WatchBackend != tt.want || cfg.WatchPollSec != tt.wantPoll) {
+			t.Errorf("normalizeWatchBackend(%q) = %q, %d; want %q, %d", tt.backend, cfg.WatchBackend, cfg.WatchPollSec, tt.want, tt.wantPoll)
+		}
+	}
+}
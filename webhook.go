@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type WebhookConfig struct {
+	Name       string            `yaml:"name"`        // label used in logs
+	URL        string            `yaml:"url"`         // endpoint; for Gotify include ?token=...
+	Format     string            `yaml:"format"`      // "json" (default), "ntfy", "gotify", "slack" or "discord"
+	Events     []string          `yaml:"events"`      // "success", "duplicate", "failure"; default all
+	Secret     string            `yaml:"secret"`      // if set, sign the body with HMAC-SHA256 in X-Downwatch-Signature
+	Headers    map[string]string `yaml:"headers"`     // extra request headers, e.g. Authorization
+	Retries    int               `yaml:"retries"`     // extra attempts after a failed delivery; default 3, -1 for none
+	TimeoutSec int               `yaml:"timeout_sec"` // per-attempt timeout; default 10
+}
+
+// Webhook event kinds
+const (
+	webhookSuccess   = "success"   // file moved or copied
+	webhookDuplicate = "duplicate" // duplicate source deleted or copy skipped
+	webhookFailure   = "failure"   // move, copy or duplicate delete failed
+)
+
+var webhookFormats = []string{"json", "ntfy", "gotify", "slack", "discord"}
+
+// webhookKind classifies an operation for webhook filtering.
+func webhookKind(e HistoryEntry) string {
+	switch {
+	case e.Error != "":
+		return webhookFailure
+	case e.Action == "delete" || e.Action == "skip":
+		return webhookDuplicate
+	default:
+		return webhookSuccess
+	}
+}
+
+// normalizeWebhooks validates webhook configs and fills in defaults.
+func normalizeWebhooks(hooks []WebhookConfig) error {
+	for i := range hooks {
+		h := &hooks[i]
+		if h.Name == "" {
+			h.Name = fmt.Sprintf("webhook %d", i+1)
+		}
+		if h.URL == "" {
+			return fmt.Errorf("webhook %q has no url", h.Name)
+		}
+		h.Format = strings.ToLower(strings.TrimSpace(h.Format))
+		if h.Format == "" {
+			h.Format = "json"
+		}
+		if !slices.Contains(webhookFormats, h.Format) {
+			return fmt.Errorf("webhook %q has invalid format %q (want one of %s)", h.Name, h.Format, strings.Join(webhookFormats, ", "))
+		}
+		if err := normalizeWebhookEvents(h.Events); err != nil {
+			return fmt.Errorf("webhook %q: %w", h.Name, err)
+		}
+		switch {
+		case h.Retries == 0:
+			h.Retries = 3
+		case h.Retries < 0:
+			h.Retries = 0
+		}
+		if h.TimeoutSec <= 0 {
+			h.TimeoutSec = 10
+		}
+	}
+	return nil
+}
+
+func normalizeWebhookEvents(events []string) error {
+	for i, ev := range events {
+		ev = strings.ToLower(strings.TrimSpace(ev))
+		if ev != webhookSuccess && ev != webhookDuplicate && ev != webhookFailure {
+			return fmt.Errorf("invalid webhook event %q (want success, duplicate or failure)", events[i])
+		}
+		events[i] = ev
+	}
+	return nil
+}
+
+// webhookWanted reports whether hook should receive an event of kind for a
+// file handled by rule r. Both the webhook's and the rule's event lists must
+// allow it; an empty list allows everything.
+func webhookWanted(hook WebhookConfig, r *Rule, kind string) bool {
+	if len(hook.Events) > 0 && !slices.Contains(hook.Events, kind) {
+		return false
+	}
+	if r != nil && len(r.WebhookEvents) > 0 && !slices.Contains(r.WebhookEvents, kind) {
+		return false
+	}
+	return true
+}
+
+// webhookPayload is the body of the generic "json" format.
+type webhookPayload struct {
+	Event   string    `json:"event"` // success, duplicate or failure
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Message string    `json:"message"`
+	Name    string    `json:"name"`
+	Rule    string    `json:"rule"`
+	Action  string    `json:"action"`
+	Source  string    `json:"source"`
+	Dest    string    `json:"dest,omitempty"`
+	Bytes   int64     `json:"bytes"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// buildWebhookRequest renders e in the hook's format. It returns the body and
+// any format-specific headers.
+func buildWebhookRequest(hook WebhookConfig, e HistoryEntry, host string) ([]byte, map[string]string, error) {
+	kind := webhookKind(e)
+	msg := e.summary()
+	title := "downwatch"
+	if host != "" {
+		title += " on " + host
+	}
+
+	switch hook.Format {
+	case "ntfy":
+		headers := map[string]string{"Title": title, "Content-Type": "text/plain; charset=utf-8"}
+		if kind == webhookFailure {
+			headers["Priority"] = "high"
+			headers["Tags"] = "warning"
+		} else {
+			headers["Tags"] = "file_folder"
+		}
+		return []byte(msg), headers, nil
+	case "gotify":
+		priority := 5
+		if kind == webhookFailure {
+			priority = 8
+		}
+		body, err := json.Marshal(map[string]any{"title": title, "message": msg, "priority": priority})
+		return body, nil, err
+	case "slack":
+		body, err := json.Marshal(map[string]string{"text": fmt.Sprintf("*%s*: %s", title, msg)})
+		return body, nil, err
+	case "discord":
+		body, err := json.Marshal(map[string]string{"content": fmt.Sprintf("**%s**: %s", title, msg)})
+		return body, nil, err
+	default:
+		body, err := json.Marshal(webhookPayload{
+			Event:   kind,
+			Time:    e.Time,
+			Host:    host,
+			Message: msg,
+			Name:    e.Name,
+			Rule:    e.Rule,
+			Action:  e.Action,
+			Source:  e.Source,
+			Dest:    e.Dest,
+			Bytes:   e.Size,
+			Error:   e.Error,
+		})
+		return body, nil, err
+	}
+}
+
+// signWebhook returns the X-Downwatch-Signature value for body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Base delay between delivery attempts; doubles after each failure
+var webhookBackoff = 2 * time.Second
+
+// deliverWebhook POSTs body to the hook, retrying network errors, 429 and 5xx responses.
+func deliverWebhook(hook WebhookConfig, body []byte, headers map[string]string) error {
+	client := &http.Client{Timeout: time.Duration(hook.TimeoutSec) * time.Second}
+	delay := webhookBackoff
+	var lastErr error
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err // malformed URL; retrying won't help
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "downwatch")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		for k, v := range hook.Headers {
+			req.Header.Set(k, v)
+		}
+		if hook.Secret != "" {
+			req.Header.Set("X-Downwatch-Signature", signWebhook(hook.Secret, body))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// sendWebhooks delivers e to every configured webhook that wants it, in the background.
+func sendWebhooks(cfg Config, e HistoryEntry) {
+	if len(cfg.Webhooks) == 0 {
+		return
+	}
+	kind := webhookKind(e)
+	r := findRule(cfg.Rules, e.Rule)
+	host, _ := os.Hostname()
+	for _, hook := range cfg.Webhooks {
+		if !webhookWanted(hook, r, kind) {
+			continue
+		}
+		body, headers, err := buildWebhookRequest(hook, e, host)
+		if err != nil {
+			slog.Error("webhook payload failed", "event", "webhook_failed", "file", e.Name, "rule", e.Rule, "dest", hook.Name, "error", err)
+			continue
+		}
+		deliveries.Go(func() {
+			if errDeliver := deliverWebhook(hook, body, headers); errDeliver != nil {
+				slog.Error("webhook delivery failed", "event", "webhook_failed", "file", e.Name, "rule", e.Rule, "dest", hook.Name, "error", errDeliver)
+			}
+		})
+	}
+}
+
+// findRule returns the rule with the given name, or nil.
+func findRule(rules []Rule, name string) *Rule {
+	for i := range rules {
+		if rules[i].Name == name {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test normalizeWebhooks defaults and validation
+func TestNormalizeWebhooks(t *testing.T) {
+	hooks := []WebhookConfig{{URL: "https://example.com/hook", Events: []string{"Failure"}}}
+	if err := normalizeWebhooks(hooks); err != nil {
+		t.Fatalf("normalizeWebhooks: %v", err)
+	}
+	h := hooks[0]
+	if h.Format != "json" || h.Retries != 3 || h.TimeoutSec != 10 || h.Events[0] != "failure" || h.Name == "" {
+		t.Errorf("defaults not applied: %+v", h)
+	}
+
+	bad := []struct {
+		name string
+		hook WebhookConfig
+	}{
+		{"no url", WebhookConfig{Name: "x"}},
+		{"bad format", WebhookConfig{URL: "http://x", Format: "teams"}},
+		{"bad event", WebhookConfig{URL: "http://x", Events: []string{"moved"}}},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := normalizeWebhooks([]WebhookConfig{tt.hook}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// Test webhook and per-rule event filters
+func TestWebhookWanted(t *testing.T) {
+	all := WebhookConfig{}
+	failuresOnly := WebhookConfig{Events: []string{webhookFailure}}
+	quietRule := &Rule{Name: "Screenshots", WebhookEvents: []string{webhookFailure}}
+
+	tests := []struct {
+		name string
+		hook WebhookConfig
+		rule *Rule
+		kind string
+		want bool
+	}{
+		{"no filters", all, nil, webhookSuccess, true},
+		{"webhook filter allows", failuresOnly, nil, webhookFailure, true},
+		{"webhook filter blocks", failuresOnly, nil, webhookDuplicate, false},
+		{"rule filter blocks", all, quietRule, webhookSuccess, false},
+		{"rule filter allows", all, quietRule, webhookFailure, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webhookWanted(tt.hook, tt.rule, tt.kind); got != tt.want {
+				t.Errorf("webhookWanted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test the built-in payload formats
+func TestBuildWebhookRequest(t *testing.T) {
+	moved := HistoryEntry{Time: time.Unix(0, 0).UTC(), Name: "a.pdf", Rule: "PDFs", Action: "move", Source: "/dl/a.pdf", Dest: "/docs/a.pdf", Size: 7}
+	failed := HistoryEntry{Name: "b.zip", Rule: "Archives", Action: "copy", Source: "/dl/b.zip", Error: "disk full"}
+
+	body, _, err := buildWebhookRequest(WebhookConfig{Format: "json"}, moved, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != webhookSuccess || p.Host != "laptop" || p.Dest != "/docs/a.pdf" || p.Bytes != 7 {
+		t.Errorf("json payload = %+v", p)
+	}
+
+	body, headers, _ := buildWebhookRequest(WebhookConfig{Format: "ntfy"}, failed, "laptop")
+	if !strings.Contains(string(body), "disk full") || headers["Priority"] != "high" || headers["Title"] != "downwatch on laptop" {
+		t.Errorf("ntfy = %q %v", body, headers)
+	}
+
+	body, _, _ = buildWebhookRequest(WebhookConfig{Format: "gotify"}, failed, "")
+	var g struct {
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(body, &g); err != nil || g.Priority != 8 || !strings.Contains(g.Message, "b.zip") {
+		t.Errorf("gotify = %s (%v)", body, err)
+	}
+
+	body, _, _ = buildWebhookRequest(WebhookConfig{Format: "slack"}, moved, "")
+	var s map[string]string
+	if err := json.Unmarshal(body, &s); err != nil || !strings.Contains(s["text"], "Moved a.pdf to /docs") {
+		t.Errorf("slack = %s (%v)", body, err)
+	}
+
+	body, _, _ = buildWebhookRequest(WebhookConfig{Format: "discord"}, moved, "")
+	var d map[string]string
+	if err := json.Unmarshal(body, &d); err != nil || !strings.Contains(d["content"], "a.pdf") {
+		t.Errorf("discord = %s (%v)", body, err)
+	}
+}
+
+// Test delivery signs the body and retries server errors
+func TestDeliverWebhook(t *testing.T) {
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var attempts atomic.Int32
+	var gotSig, gotAuth string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gotSig = r.Header.Get("X-Downwatch-Signature")
+		gotAuth = r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	hook := WebhookConfig{URL: srv.URL, Secret: "s3cret", Retries: 3, TimeoutSec: 5, Headers: map[string]string{"Authorization": "Bearer t"}}
+	body := []byte(`{"event":"success"}`)
+	if err := deliverWebhook(hook, body, nil); err != nil {
+		t.Fatalf("deliverWebhook: %v", err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("attempts = %d, want 3", attempts.Load())
+	}
+	if string(gotBody) != string(body) || gotAuth != "Bearer t" {
+		t.Errorf("body %q auth %q", gotBody, gotAuth)
+	}
+	// HMAC-SHA256 of the body with key "s3cret"
+	if want := "sha256=ea6a0461318f7df5dc74a270535a7c2fe8854c01578495cea7325a2c372b39ba"; gotSig != want {
+		t.Errorf("signature = %q, want %q", gotSig, want)
+	}
+}
+
+// Test client errors are not retried
+func TestDeliverWebhookNoRetryOn4xx(t *testing.T) {
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	err := deliverWebhook(WebhookConfig{URL: srv.URL, Retries: 3, TimeoutSec: 5}, []byte("{}"), nil)
+	if err == nil || attempts.Load() != 1 {
+		t.Errorf("err = %v attempts = %d, want error after 1 attempt", err, attempts.Load())
+	}
+}
+
+// Test WebDAV upload failures and stability timeouts reach failure webhooks
+func TestWebhookPipelineFailures(t *testing.T) {
+	got := make(chan webhookPayload, 4)
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		got <- p
+	}))
+	defer hookSrv.Close()
+	davSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInsufficientStorage)
+	}))
+	defer davSrv.Close()
+
+	dir := t.TempDir()
+	cfg := onceConfig(t, dir)
+	cfg.Stability = []string{checkSize}
+	cfg.Webhooks = []WebhookConfig{{URL: hookSrv.URL, Events: []string{webhookFailure}}}
+	if err := normalizeWebhooks(cfg.Webhooks); err != nil {
+		t.Fatal(err)
+	}
+	oneSec := 1
+	cfg.Rules[0].MaxWaitSec = &oneSec
+	cfg.Rules[0].WebDAVUpload = true
+	cfg.Rules[0].WebDAVPath = "/inbox/"
+	cfg.WebDAV = WebDAVConfig{URL: davSrv.URL, TimeoutSec: 5}
+	dav := davClient(cfg.WebDAV)
+
+	// Uploaded after filing, and the server is full
+	doc := filepath.Join(cfg.WatchDir, "a.pdf")
+	writeFile(t, doc, "%PDF-1.4")
+	handleFile(doc, cfg, dav, handleOptions{skipStability: true})
+
+	// Still growing at max_wait_seconds
+	big := filepath.Join(cfg.WatchDir, "big.pdf")
+	writeFile(t, big, "x")
+	stop := make(chan struct{})
+	defer close(stop)
+	go growFile(big, stop)
+	handleFile(big, cfg, dav, handleOptions{})
+
+	want := map[string]string{"upload": "a.pdf", "stability": "big.pdf"}
+	for range len(want) {
+		select {
+		case p := <-got:
+			if p.Event != webhookFailure || want[p.Action] != p.Name || p.Error == "" {
+				t.Errorf("webhook payload = %+v", p)
+			}
+			delete(want, p.Action)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no webhook for %v", want)
+		}
+	}
+}